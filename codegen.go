@@ -1,13 +1,81 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"math/big"
+)
 
-var argreg = []string{"rdi", "rsi", "rdx", "rcx", "r8", "r9"} // 第1引数から第6引数をセットするレジスタ
+// 第1引数から第6引数をセットするレジスタ 第7引数以降はスタックに積んで渡す
+// スライスのような複数ワードの値はワードごとに一つの引数として渡す
+var argreg = []string{"rdi", "rsi", "rdx", "rcx", "r8", "r9"}
+
+// 関数値を呼び出すときにクロージャのアドレスをセットするレジスタ
+// 引数にも結果にも使わないSystem V ABIの静的チェーンのレジスタで、捕捉した変数はクロージャから読み出す
+const ctxreg = "r10"
+
+// 関数の結果を順にセットするレジスタ
+// 結果が1個か2個のときはSystem V ABIと同じくraxとrdxを使うので、Cの関数とも呼び合える
+// レジスタに収まらないときは呼び出し元がリターンアドレスの上に結果のワード数分の領域を確保し、
+// 呼び出された関数はレジスタに収まらない結果をその領域に、スタックに積んだときと同じ並びで書き込む
+var retreg = []string{"rax", "rdx", "rcx", "rsi", "rdi", "r8", "r9", "r10", "r11"}
+
+// 型typesの値をpush_valuesでスタックに積んだときの、j番目のワードのスタックトップからのオフセット
+func stack_word_offset(types []*Type, j int) int {
+	n := total_words(types)
+	begin := 0
+	for _, ty := range types {
+		end := begin + words(ty)
+		if j < end {
+			return (n - begin - end + j) * 8
+		}
+		begin = end
+	}
+	panic("unreachable")
+}
+
+// 型paramsの引数のうちスタックで渡すワード数
+func stack_args(params []*Type) int {
+	if total_words(params) <= len(argreg) {
+		return 0
+	}
+	return total_words(params) - len(argreg)
+}
+
+// 型resultsの結果を受け取るために呼び出し元がスタックで渡す引数の上に確保するワード数
+func result_area(results []*Type) int {
+	if total_words(results) <= len(retreg) {
+		return 0
+	}
+	return total_words(results)
+}
+
+// 関数fnの結果のワードごとの書き込み先
+// レジスタに収まらないワードは呼び出し元の領域になる
+// rbpの上には退避したrbp, r15, r14, r13, r12, rbxとリターンアドレスとスタックで渡された引数がある
+func result_dests(fn *Node) []string {
+	dests := []string{}
+	for j := 0; j < total_words(fn.ty.results); j++ {
+		if j < len(retreg) {
+			dests = append(dests, retreg[j])
+		} else {
+			offset := 56 + stack_args(fn.ty.params)*8 + stack_word_offset(fn.ty.results, j)
+			dests = append(dests, fmt.Sprintf("QWORD PTR [rbp+%d]", offset))
+		}
+	}
+	return dests
+}
 
 type Codegen struct {
-	code       string
-	program    []*Node
-	current_fn *Node
+	code         string
+	program      []*Node
+	globals      []*Node           // パッケージレベルの変数宣言
+	freestanding bool              // libcのスタートアップルーチンを使わず、_startからプログラムを始める
+	types        map[string]string // 型情報のラベル
+	typeinfos    []string          // 出力する型情報
+	funcvals     []string          // 静的なクロージャを出力する関数のシンボル
+	itabs        []*Itab           // 出力するインターフェースのメソッドの表
+	current_fn   *Node
+	depth        int // 関数の本体でスタックに積んでいるワード数
 }
 
 var counter int = 0
@@ -17,65 +85,732 @@ func count() int {
 	return counter
 }
 
+// 関数のシンボル名 Goの関数はパッケージ名で修飾し、Cの関数と衝突しないようにする
+func symbol(fn *Node) string {
+	if fn.external {
+		return fn.val
+	}
+	return "main." + fn.val
+}
+
+// 定数の値の下位64ビットを符号付き整数として表した即値
+// 型で表せることは検査済みなので、uint64の大きな値も同じビット列の負の数になるだけ
+func immediate(v *big.Int) int64 {
+	mask := new(big.Int).SetUint64(^uint64(0))
+	return int64(new(big.Int).And(v, mask).Uint64())
+}
+
 func (cg *Codegen) gen_addr(node *Node) {
 	switch node.kind {
 	case ND_VAR:
+		if node.variable.symbol != "" {
+			fmt.Printf("  lea   rax, [rip+%s]\n", node.variable.symbol)
+			cg.push("rax") // パッケージレベルの変数のアドレスをスタックに積む
+			return
+		}
+		if node.variable.heap {
+			cg.push("[rbp-%d]", node.variable.offset) // ヒープに割り当てた変数のアドレスをスタックに積む
+			return
+		}
 		fmt.Printf("  mov   rax, rbp\n")
 		fmt.Printf("  sub   rax, %d\n", node.variable.offset)
-		fmt.Printf("  push  rax\n") // 変数のアドレスをスタックに積む
+		cg.push("rax") // 変数のアドレスをスタックに積む
 	case ND_DEREF:
 		cg.gen_expr(node.lhs) // lhsを評価しスタックに積む
+	case ND_MEMBER:
+		if node.lhs.ty.kind == TY_PTR {
+			cg.gen_expr(node.lhs) // 構造体へのポインタをスタックに積む
+		} else {
+			cg.gen_addr(node.lhs) // 構造体のアドレスをスタックに積む
+		}
+		if node.field.offset > 0 {
+			fmt.Printf("  add   QWORD PTR [rsp], %d\n", node.field.offset) // フィールドのアドレスにする
+		}
+	case ND_INDEX:
+		c := count()
+		cg.gen_expr(node.lhs) // スライスをスタックに積み
+		cg.gen_expr(node.rhs) // インデックスをスタックに積む
+		cg.pop("rdi")
+		fmt.Printf("  cmp   rdi, [rsp+8]\n") // 符号なしで比較すれば負のインデックスも範囲外になる
+		fmt.Printf("  jb    .L.inbounds.%d\n", c)
+		fmt.Printf("  mov   rdx, rdi\n")
+		fmt.Printf("  mov   rcx, [rsp+8]\n")
+		cg.gen_panic("runtime.panicindex", node.token)
+		fmt.Printf(".L.inbounds.%d:\n", c)
+		cg.pop("rax")
+		cg.drop(2)
+		fmt.Printf("  imul  rdi, %d\n", node.ty.size)
+		fmt.Printf("  add   rax, rdi\n")
+		cg.push("rax") // 要素のアドレスをスタックに積む
 	default:
 		error_tok(cg.code, node.token, "アドレスが取得できません")
 	}
 }
 
+// 組み込み関数printとprintlnで引数を標準エラー出力に書き込む
+// 引数をすべて評価してから、型ごとのランタイムルーチンで一つずつ書き込む printlnは引数を空白で区切り、最後に改行する
+func (cg *Codegen) gen_print(node *Node) {
+	total := 0
+	for _, arg := range node.args {
+		cg.gen_expr(arg)
+		total += words(arg.ty)
+	}
+	offset := total
+	for i, arg := range node.args {
+		if node.val == "println" && i > 0 {
+			fmt.Printf("  call  runtime.printsp\n")
+		}
+		offset -= words(arg.ty)
+		for j := 0; j < words(arg.ty); j++ {
+			fmt.Printf("  mov   %s, [rsp+%d]\n", argreg[j], (offset+j)*8)
+		}
+		switch {
+		case arg.ty.kind == TY_PTR || arg.ty.kind == TY_FUNC:
+			fmt.Printf("  call  runtime.printpointer\n")
+		case arg.ty.kind == TY_SLICE:
+			fmt.Printf("  call  runtime.printslice\n")
+		case is_bool(arg.ty):
+			fmt.Printf("  call  runtime.printbool\n")
+		case is_unsigned(arg.ty):
+			fmt.Printf("  call  runtime.printuint\n")
+		default:
+			fmt.Printf("  call  runtime.printint\n")
+		}
+	}
+	if node.val == "println" {
+		fmt.Printf("  call  runtime.printnl\n")
+	}
+	cg.drop(total)
+}
+
+// raxが指しているアドレスから型tyの値を読み込み、スタックに積む
+// 複数ワードの値は先頭のワードがスタックの一番上になるように積む
+func (cg *Codegen) push_value(ty *Type) {
+	if words(ty) == 1 {
+		cg.load(ty)
+		cg.push("rax")
+		return
+	}
+	for i := words(ty) - 1; i >= 0; i-- {
+		cg.push("QWORD PTR [rax+%d]", i*8)
+	}
+}
+
+// スタックのoffsetの位置にある型tyの値をrdiが指しているアドレスに書き込む
+func (cg *Codegen) store_value(ty *Type, offset int) {
+	if words(ty) == 1 {
+		fmt.Printf("  mov   rax, [rsp+%d]\n", offset)
+		cg.store(ty)
+		return
+	}
+	for i := 0; i < words(ty); i++ {
+		fmt.Printf("  mov   rax, [rsp+%d]\n", offset+i*8)
+		fmt.Printf("  mov   [rdi+%d], rax\n", i*8)
+	}
+}
+
+// スタックに積んだ型typesの値をワードごとにdestsにポップする
+// destsのi番目には値を並べたときのi番目のワードが入る
+func (cg *Codegen) pop_values(dests []string, types []*Type) {
+	end := total_words(types)
+	for i := len(types) - 1; i >= 0; i-- {
+		begin := end - words(types[i])
+		for j := begin; j < end; j++ {
+			cg.pop("%s", dests[j])
+		}
+		end = begin
+	}
+}
+
+// srcsに入っている型typesの値をスタックに積む pop_valuesの逆
+func (cg *Codegen) push_values(srcs []string, types []*Type) {
+	begin := 0
+	for _, ty := range types {
+		end := begin + words(ty)
+		for j := end - 1; j >= begin; j-- {
+			cg.push("%s", srcs[j])
+		}
+		begin = end
+	}
+}
+
+// スタックに値を積む
+// 積んでいるワード数を数えておき、関数呼び出しの前にrspを16の倍数に揃える
+func (cg *Codegen) push(format string, a ...interface{}) {
+	fmt.Printf("  push  "+format+"\n", a...)
+	cg.depth++
+}
+
+// スタックから値を取り出す
+func (cg *Codegen) pop(format string, a ...interface{}) {
+	fmt.Printf("  pop   "+format+"\n", a...)
+	cg.depth--
+}
+
+// スタックに積んだnワードを捨てる
+func (cg *Codegen) drop(n int) {
+	if n > 0 {
+		fmt.Printf("  add   rsp, %d\n", n*8)
+		cg.depth -= n
+	}
+}
+
+// sizeバイトのゼロ値の領域をヒープに割り当て、そのアドレスをraxにセットする
+// typeinfoは領域に並ぶ要素のポインタの位置を表す型情報のラベルで、ポインタを含まなければ空文字列
+func (cg *Codegen) gen_newobject(size int, typeinfo string) {
+	fmt.Printf("  mov   rdi, %d\n", size)
+	if typeinfo == "" {
+		fmt.Printf("  xor   esi, esi\n")
+	} else {
+		fmt.Printf("  lea   rsi, [rip+%s]\n", typeinfo)
+	}
+	fmt.Printf("  call  runtime.newobject\n")
+}
+
+// シンボルfnの関数の関数値になる静的なクロージャのラベル
+// クロージャは関数のアドレスと捕捉した変数へのポインタを並べた領域で、捕捉した変数がなければ関数のアドレスだけになる
+func (cg *Codegen) funcval(fn string) string {
+	label := fn + "·f"
+	for _, f := range cg.funcvals {
+		if f == fn {
+			return label
+		}
+	}
+	cg.funcvals = append(cg.funcvals, fn)
+	return label
+}
+
+// 要素の大きさがsizeバイトで、ポインタのワードの位置がptrsの型情報のラベル
+// 型情報は要素の大きさと、64ワードごとに1ワードのポインタのワードのビットマップからなる
+// ガベージコレクタはこの型情報を使ってヒープの領域の中のポインタを正確にたどる
+func (cg *Codegen) typeinfo(size int, ptrs []int) string {
+	if len(ptrs) == 0 {
+		return "" // ポインタを含まない領域はたどらない
+	}
+	mask := make([]uint64, (align_to(size, 8)/8+63)/64)
+	for _, i := range ptrs {
+		mask[i/64] |= 1 << (i % 64)
+	}
+	key := fmt.Sprintf("%d%x", size, mask)
+	if label, ok := cg.types[key]; ok {
+		return label
+	}
+	label := fmt.Sprintf(".L.type.%d", count())
+	cg.types[key] = label
+	info := fmt.Sprintf("%s:\n  .quad %d\n", label, size)
+	for _, m := range mask {
+		info += fmt.Sprintf("  .quad %d\n", m)
+	}
+	cg.typeinfos = append(cg.typeinfos, info)
+	return label
+}
+
+// 型tyの値を要素とする領域の型情報のラベル
+func (cg *Codegen) type_info(ty *Type) string {
+	return cg.typeinfo(ty.size, ptrwords(ty))
+}
+
+// raxが指しているアドレスから型tyの値を読み込み、raxにセットする
+func (cg *Codegen) load(ty *Type) {
+	switch {
+	case ty.size == 1 && is_unsigned(ty):
+		fmt.Printf("  movzx rax, BYTE PTR [rax]\n")
+	case ty.size == 1:
+		fmt.Printf("  movsx rax, BYTE PTR [rax]\n")
+	case ty.size == 2 && is_unsigned(ty):
+		fmt.Printf("  movzx rax, WORD PTR [rax]\n")
+	case ty.size == 2:
+		fmt.Printf("  movsx rax, WORD PTR [rax]\n")
+	case ty.size == 4 && is_unsigned(ty):
+		fmt.Printf("  mov   eax, DWORD PTR [rax]\n")
+	case ty.size == 4:
+		fmt.Printf("  movsxd rax, DWORD PTR [rax]\n")
+	default:
+		fmt.Printf("  mov   rax, [rax]\n")
+	}
+}
+
+// raxの値を型tyの値としてrdiが指しているアドレスに書き込む
+func (cg *Codegen) store(ty *Type) {
+	switch ty.size {
+	case 1:
+		fmt.Printf("  mov   [rdi], al\n")
+	case 2:
+		fmt.Printf("  mov   [rdi], ax\n")
+	case 4:
+		fmt.Printf("  mov   [rdi], eax\n")
+	default:
+		fmt.Printf("  mov   [rdi], rax\n")
+	}
+}
+
+// raxの値を型tyの大きさに切り詰め、符号拡張またはゼロ拡張する
+func (cg *Codegen) truncate(ty *Type) {
+	if ty.kind == TY_BOOL {
+		fmt.Printf("  movzx rax, al\n") // Cの_Boolは下位8ビットだけが意味を持つ
+		return
+	}
+	if ty.kind != TY_INT {
+		return
+	}
+	switch {
+	case ty.size == 1 && ty.unsigned:
+		fmt.Printf("  movzx rax, al\n")
+	case ty.size == 1:
+		fmt.Printf("  movsx rax, al\n")
+	case ty.size == 2 && ty.unsigned:
+		fmt.Printf("  movzx rax, ax\n")
+	case ty.size == 2:
+		fmt.Printf("  movsx rax, ax\n")
+	case ty.size == 4 && ty.unsigned:
+		fmt.Printf("  mov   eax, eax\n")
+	case ty.size == 4:
+		fmt.Printf("  movsxd rax, eax\n")
+	}
+}
+
+// パニックした位置を表す文字列を引数にしてランタイムのパニック関数を呼び出す
+func (cg *Codegen) gen_panic(fn string, token *Token) {
+	c := count()
+	fmt.Printf("  .section .rodata\n")
+	fmt.Printf(".L.pos.%d:\n", c)
+	fmt.Printf("  .ascii \"%s()\\n\\t[%d:%d]\"\n", symbol(cg.current_fn), token.line, token.col)
+	fmt.Printf(".L.pos.%d.end:\n", c)
+	fmt.Printf("  .text\n")
+	fmt.Printf("  lea   rdi, [rip+.L.pos.%d]\n", c)
+	fmt.Printf("  mov   esi, OFFSET .L.pos.%d.end - .L.pos.%d\n", c, c)
+	fmt.Printf("  call  %s\n", fn) // パニック関数からは戻らない
+}
+
+// シフト演算nodeのシフト回数rdiが負ならパニックする
+func (cg *Codegen) gen_shiftcheck(node *Node) {
+	if is_unsigned(node.rhs.ty) {
+		return // 符号なし整数は負にならない
+	}
+	c := count()
+	fmt.Printf("  test  rdi, rdi\n")
+	fmt.Printf("  jns   .L.shiftok.%d\n", c)
+	cg.gen_panic("runtime.panicshift", node.token)
+	fmt.Printf(".L.shiftok.%d:\n", c)
+}
+
+// 呼び出す関数値のクロージャがnilならパニックする
+func (cg *Codegen) gen_nilcheck(token *Token) {
+	c := count()
+	fmt.Printf("  test  %s, %s\n", ctxreg, ctxreg)
+	fmt.Printf("  jne   .L.nilok.%d\n", c)
+	cg.gen_panic("runtime.panicnil", token)
+	fmt.Printf(".L.nilok.%d:\n", c)
+}
+
+// 呼び出すとtokenの位置でnilの関数値の呼び出しとしてパニックする関数を出力し、その静的なクロージャのラベルを返す
+func (cg *Codegen) gen_nilfunc(token *Token) string {
+	c := count()
+	fmt.Printf("  jmp   .L.nilfunc.end.%d\n", c)
+	fmt.Printf(".L.nilfunc.%d:\n", c)
+	cg.gen_panic("runtime.panicnil", token)
+	fmt.Printf(".L.nilfunc.end.%d:\n", c)
+	fmt.Printf("  .data\n")
+	fmt.Printf(".L.nilfunc.%d.f:\n", c)
+	fmt.Printf("  .quad .L.nilfunc.%d\n", c)
+	fmt.Printf("  .text\n")
+	return fmt.Sprintf(".L.nilfunc.%d.f", c)
+}
+
+// 除算nodeの除数rdiが0ならパニックする
+func (cg *Codegen) gen_divcheck(node *Node) {
+	c := count()
+	fmt.Printf("  test  rdi, rdi\n")
+	fmt.Printf("  jne   .L.divok.%d\n", c)
+	cg.gen_panic("runtime.panicdivide", node.token)
+	fmt.Printf(".L.divok.%d:\n", c)
+}
+
 func (cg *Codegen) gen_expr(node *Node) {
 	switch node.kind {
 	case ND_NUM:
-		fmt.Printf("  push  %s\n", node.val) // 整数リテラルをスタックに積む
+		fmt.Printf("  mov   rax, %d\n", immediate(node.num))
+		cg.push("rax") // 整数リテラルをスタックに積む
 		return
-	case ND_VAR:
-		cg.gen_addr(node)             // 変数のアドレスをスタックに積む
-		fmt.Printf("  pop   rax\n")   // 変数のアドレスをポップ
-		fmt.Printf("  push  [rax]\n") // 変数の値をスタックに積む
+	case ND_ZERO:
+		for i := 0; i < words(node.ty); i++ {
+			cg.push("0") // ゼロ値をスタックに積む
+		}
 		return
-	case ND_DEREF:
-		cg.gen_expr(node.lhs)         // lhsを評価しスタックに積む
-		fmt.Printf("  pop   rax\n")   // 変数のアドレスをポップ
-		fmt.Printf("  push  [rax]\n") // 変数の値をスタックに積む
+	case ND_VAR, ND_DEREF, ND_INDEX:
+		cg.gen_addr(node)      // 変数のアドレスをスタックに積む
+		cg.pop("rax")          // 変数のアドレスをポップ
+		cg.push_value(node.ty) // 変数の値を読み込みスタックに積む
+		return
+	case ND_MEMBER:
+		if addressable(node) {
+			cg.gen_addr(node)
+			cg.pop("rax")
+			cg.push_value(node.ty)
+			return
+		}
+		// 関数呼び出しの結果などの構造体の値はスタックに積み、そのフィールドの値だけを残す
+		n, m := words(node.lhs.ty), words(node.ty)
+		cg.gen_expr(node.lhs)
+		fmt.Printf("  lea   rax, [rsp+%d]\n", node.field.offset)
+		cg.push_value(node.ty)
+		for k := m - 1; k >= 0; k-- {
+			fmt.Printf("  mov   rax, [rsp+%d]\n", k*8)
+			fmt.Printf("  mov   [rsp+%d], rax\n", (n+k)*8)
+		}
+		cg.drop(n)
+		return
+	case ND_IFACE:
+		// データのワードを積んでから、メソッドの表のアドレスを積む
+		cg.gen_expr(node.lhs)
+		if ty := node.lhs.ty; !direct_iface(ty) {
+			cg.gen_newobject(ty.size, cg.type_info(ty)) // 値をヒープにコピーしてそのアドレスをデータのワードにする
+			fmt.Printf("  mov   rdi, rax\n")
+			cg.store_value(ty, 0)
+			fmt.Printf("  mov   rax, rdi\n")
+			cg.drop(words(ty))
+			cg.push("rax")
+		}
+		fmt.Printf("  lea   rax, [rip+%s]\n", cg.itab(node.itab))
+		cg.push("rax")
+		return
+	case ND_IMETHOD:
+		// メソッドの表の中のラッパー関数のアドレスの位置を関数値として積み、その上にデータのワードを積む
+		// nilのインターフェースの値ではnilの関数値になる
+		c := count()
+		cg.gen_expr(node.lhs)
+		cg.pop("rax")
+		cg.pop("rdi")
+		fmt.Printf("  test  rax, rax\n")
+		fmt.Printf("  je    .L.nilitab.%d\n", c)
+		fmt.Printf("  add   rax, %d\n", node.offset)
+		fmt.Printf(".L.nilitab.%d:\n", c)
+		cg.push("rax")
+		cg.push("rdi")
+		return
+	case ND_LEN, ND_CAP:
+		cg.gen_expr(node.lhs) // スライスをスタックに積み
+		if node.kind == ND_LEN {
+			fmt.Printf("  mov   rax, [rsp+8]\n")
+		} else {
+			fmt.Printf("  mov   rax, [rsp+16]\n")
+		}
+		cg.drop(3)
+		cg.push("rax") // 長さか容量をスタックに積む
+		return
+	case ND_PRINT:
+		cg.gen_print(node)
+		return
+	case ND_NEW:
+		cg.gen_newobject(node.ty.base.size, cg.type_info(node.ty.base)) // ゼロ値の領域をヒープに割り当て
+		cg.push("rax")                                                  // そのアドレスをスタックに積む
+		return
+	case ND_SLICELIT:
+		// 要素の配列をヒープに割り当て、要素を順に書き込む
+		n := len(node.args)
+		cg.gen_newobject(n*node.ty.base.size, cg.type_info(node.ty.base))
+		cg.push("rax")
+		for i, arg := range node.args {
+			cg.gen_expr(arg)
+			fmt.Printf("  mov   rdi, [rsp+%d]\n", words(node.ty.base)*8)
+			fmt.Printf("  add   rdi, %d\n", i*node.ty.base.size)
+			cg.store_value(node.ty.base, 0)
+			cg.drop(words(node.ty.base))
+		}
+		cg.pop("rax")
+		cg.push("%d", n) // 容量
+		cg.push("%d", n) // 長さ
+		cg.push("rax")   // 配列の先頭のアドレス
+		return
+	case ND_PACK:
+		// 関数呼び出しの結果を積み、可変長引数になる後ろの値をスライスにまとめる
+		cg.gen_expr(node.lhs)
+		results := node.lhs.ty.results
+		elem := node.ty.results[len(node.ty.results)-1].base
+		rest := results[len(node.ty.results)-1:]
+		if len(rest) == 0 {
+			cg.push("0") // 可変長引数に渡す値がなければnil
+			cg.push("0")
+			cg.push("0")
+			return
+		}
+		n := len(rest)
+		cg.gen_newobject(n*elem.size, cg.type_info(elem))
+		cg.push("rax")
+		offset := total_words(rest) * 8 // 後ろの値ほどスタックの上にある
+		for i, ty := range rest {
+			offset -= words(ty) * 8
+			fmt.Printf("  mov   rdi, [rsp]\n")
+			fmt.Printf("  add   rdi, %d\n", i*elem.size)
+			cg.store_value(elem, offset+8)
+		}
+		cg.pop("rax")
+		cg.drop(total_words(rest))
+		cg.push("%d", n) // 容量
+		cg.push("%d", n) // 長さ
+		cg.push("rax")   // 配列の先頭のアドレス
 		return
 	case ND_ADDR:
 		cg.gen_addr(node.lhs) // 変数のアドレスをスタックに積む
 		return
+	case ND_CONV:
+		cg.gen_expr(node.lhs) // lhsを評価しスタックに積む
+		if words(node.ty) == 1 {
+			cg.pop("rax")        // 値をポップし
+			cg.truncate(node.ty) // 変換先の型の大きさに切り詰め
+			cg.push("rax")       // スタックに積む
+		}
+		return
+	case ND_BITNOT:
+		cg.gen_expr(node.lhs)
+		cg.pop("rax")
+		fmt.Printf("  not   rax\n")
+		cg.truncate(node.ty)
+		cg.push("rax")
+		return
+	case ND_LOGAND:
+		c := count()
+		cg.gen_expr(node.lhs)
+		cg.pop("rax")
+		fmt.Printf("  cmp   rax, 0\n")
+		fmt.Printf("  je    .L.false.%d\n", c) // lhsがfalseならrhsは評価しない
+		cg.gen_expr(node.rhs)
+		cg.pop("rax")
+		fmt.Printf("  cmp   rax, 0\n")
+		fmt.Printf("  je    .L.false.%d\n", c)
+		fmt.Printf("  mov   eax, 1\n")
+		fmt.Printf("  jmp   .L.end.%d\n", c)
+		fmt.Printf(".L.false.%d:\n", c)
+		fmt.Printf("  mov   eax, 0\n")
+		fmt.Printf(".L.end.%d:\n", c)
+		cg.push("rax")
+		return
+	case ND_LOGOR:
+		c := count()
+		cg.gen_expr(node.lhs)
+		cg.pop("rax")
+		fmt.Printf("  cmp   rax, 0\n")
+		fmt.Printf("  jne   .L.true.%d\n", c) // lhsがtrueならrhsは評価しない
+		cg.gen_expr(node.rhs)
+		cg.pop("rax")
+		fmt.Printf("  cmp   rax, 0\n")
+		fmt.Printf("  jne   .L.true.%d\n", c)
+		fmt.Printf("  mov   eax, 0\n")
+		fmt.Printf("  jmp   .L.end.%d\n", c)
+		fmt.Printf(".L.true.%d:\n", c)
+		fmt.Printf("  mov   eax, 1\n")
+		fmt.Printf(".L.end.%d:\n", c)
+		cg.push("rax")
+		return
+	case ND_FUNCLIT, ND_FUNCNAME:
+		cg.gen_closure(node.callee)
+		return
 	case ND_FUNCCALL:
+		if node.callee == nil {
+			cg.gen_expr(node.lhs) // 関数値を評価しスタックに積む
+		}
 		for _, v := range node.args {
 			cg.gen_expr(v) // 引数を評価しスタックに積む
 		}
-		argc := len(node.args)
-		for i := argc - 1; i >= 0; i-- {
-			fmt.Printf("  pop   %s\n", argreg[i]) // 引数をレジスタにセット
+		types := call_types(node)
+		results := value_types([]*Node{node})
+		if node.callee == nil {
+			// クロージャをセットし、その先頭の関数のアドレスを呼び出す
+			fmt.Printf("  mov   %s, [rsp+%d]\n", ctxreg, total_words(types)*8)
+			cg.gen_nilcheck(node.token)
+			cg.gen_call(fmt.Sprintf("QWORD PTR [%s]", ctxreg), types, results, 1)
+		} else {
+			cg.gen_call(symbol(node.callee), types, results, 0)
 		}
-		fmt.Printf("  call  %s\n", node.val) // raxに関数の返り値がセットされる
-		fmt.Printf("  push  rax\n")          // スタックに関数の返り値を積む
 		return
 	}
 
 	cg.gen_expr(node.lhs)
 	cg.gen_expr(node.rhs)
-	fmt.Printf("  pop   rdi\n")
-	fmt.Printf("  pop   rax\n")
+	if w := words(node.lhs.ty); w > 1 {
+		// スライスとインターフェースはnilとしか比較しないので、配列へのポインタかメソッドの表へのポインタだけを比較する
+		fmt.Printf("  mov   rdi, [rsp]\n")
+		fmt.Printf("  mov   rax, [rsp+%d]\n", w*8)
+		cg.drop(2 * w)
+	} else {
+		cg.pop("rdi")
+		cg.pop("rax")
+	}
 
+	ty := node.ty
 	switch node.kind {
+	case ND_EQ, ND_NE, ND_LT, ND_LE:
+		ty = node.lhs.ty // 比較は被演算子の型で行う
+		if ty.kind == TY_UNTYPED_INT {
+			ty = node.rhs.ty
+		}
+	case ND_SHL, ND_SHR:
+		cg.gen_shiftcheck(node)
+	case ND_DIV, ND_MOD:
+		cg.gen_divcheck(node)
+	}
+	cg.gen_binary(node.kind, ty)
+	cg.push("rax") // 計算した値をスタックに積む
+}
+
+// 関数呼び出しnodeで渡す引数の型
+// インターフェースのメソッドの呼び出しでは、データのワードをレシーバとして最初に渡す
+func call_types(node *Node) []*Type {
+	types := value_types(node.args)
+	if node.lhs != nil && node.lhs.kind == ND_IMETHOD {
+		return append([]*Type{ty_data}, types...)
+	}
+	return types
+}
+
+// メソッドの表itabを出力する表に加え、そのラベルを返す
+func (cg *Codegen) itab(itab *Itab) string {
+	for _, t := range cg.itabs {
+		if t == itab {
+			return itab.label
+		}
+	}
+	cg.itabs = append(cg.itabs, itab)
+	return itab.label
+}
+
+// 関数リテラルfnのクロージャを作り、そのアドレスをスタックに積む
+// 捕捉した変数はヒープに割り当ててあり、クロージャにはそのヒープ領域へのポインタを入れる
+func (cg *Codegen) gen_closure(fn *Node) {
+	if len(fn.captures) == 0 {
+		fmt.Printf("  lea   rax, [rip+%s]\n", cg.funcval(symbol(fn)))
+		cg.push("rax") // 捕捉した変数がなければ静的なクロージャを使う
+		return
+	}
+	size := 8 + len(fn.captures)*8
+	ptrs := []int{} // 関数のアドレスの後ろはすべてポインタ
+	for i := range fn.captures {
+		ptrs = append(ptrs, 1+i)
+	}
+	cg.gen_newobject(size, cg.typeinfo(size, ptrs))
+	cg.push("rax")
+	fmt.Printf("  lea   rdi, [rip+%s]\n", symbol(fn))
+	fmt.Printf("  mov   [rax], rdi\n")
+	for i, variable := range fn.captures {
+		cg.gen_addr(&Node{kind: ND_VAR, variable: variable.capture}) // 外側の関数の変数のヒープ領域のアドレス
+		cg.pop("rdi")
+		fmt.Printf("  mov   rax, [rsp]\n")
+		fmt.Printf("  mov   [rax+%d], rdi\n", 8+i*8)
+	}
+}
+
+// スタックに積んだ型paramsの引数で関数fnを呼び出し、引数を取り除いて型resultsの結果をスタックに積む
+// 引数の下に積んだbelowワード(呼び出す関数値)も取り除く
+// 第7ワード以降の引数は積んでいる値の上に並べ直して渡す
+// 結果がレジスタに収まらなければスタックで渡す引数の上に結果の領域を確保する
+func (cg *Codegen) gen_call(fn string, params []*Type, results []*Type, below int) {
+	n := total_words(params)
+	stack := stack_args(params)
+	area := result_area(results)
+	pad := (cg.depth + stack + area) % 2 // call命令を実行するときにrspが16の倍数になるように揃える
+	if stack+area+pad > 0 {
+		fmt.Printf("  sub   rsp, %d\n", (stack+area+pad)*8)
+		cg.depth += stack + area + pad
+	}
+
+	// 引数のj番目のワードはi番目の値のワードで、値は後ろのものほどスタックの上にある
+	offset := (stack + area + pad + n) * 8
+	j := 0
+	for _, ty := range params {
+		offset -= words(ty) * 8
+		for w := 0; w < words(ty); w++ {
+			if j < len(argreg) {
+				fmt.Printf("  mov   %s, [rsp+%d]\n", argreg[j], offset+w*8)
+			} else {
+				fmt.Printf("  mov   rax, [rsp+%d]\n", offset+w*8)
+				fmt.Printf("  mov   [rsp+%d], rax\n", (j-len(argreg))*8)
+			}
+			j++
+		}
+	}
+	fmt.Printf("  mov   eax, 0\n") // 可変長引数のCの関数のためにalにベクトルレジスタで渡す引数の個数をセット
+	fmt.Printf("  call  %s\n", fn) // retregに関数の結果がセットされる
+	if len(results) == 1 {
+		cg.truncate(results[0]) // Cの関数は結果のレジスタの上位ビットを不定のまま返す
+	}
+	if area == 0 {
+		cg.drop(stack + pad + n + below)
+		cg.push_values(retreg, results) // スタックに関数の結果を積む
+		return
+	}
+
+	// レジスタの結果を領域に並べてから、領域全体を引数があった位置に上から順に移す
+	for j := range retreg {
+		fmt.Printf("  mov   [rsp+%d], %s\n", stack*8+stack_word_offset(results, j), retreg[j])
+	}
+	for k := area - 1; k >= 0; k-- {
+		fmt.Printf("  mov   rax, [rsp+%d]\n", (stack+k)*8)
+		fmt.Printf("  mov   [rsp+%d], rax\n", (stack+pad+n+below+k)*8)
+	}
+	cg.drop(stack + pad + n + below)
+}
+
+// 型tyのraxとrdiの二項演算を行い、結果をraxにセットする
+func (cg *Codegen) gen_binary(kind NodeKind, ty *Type) {
+	switch kind {
 	case ND_ADD:
 		fmt.Printf("  add   rax, rdi\n")
 	case ND_SUB:
 		fmt.Printf("  sub   rax, rdi\n")
 	case ND_MUL:
 		fmt.Printf("  imul  rax, rdi\n")
-	case ND_DIV:
-		fmt.Printf("  cqo\n")
-		fmt.Printf("  idiv  rdi\n")
+	case ND_DIV, ND_MOD:
+		if is_unsigned(ty) {
+			fmt.Printf("  xor   edx, edx\n")
+			fmt.Printf("  div   rdi\n")
+		} else {
+			// 最小値を-1で割るとidivが例外を起こすので、-1で割る場合は符号反転で計算する
+			// 商はオーバーフローして最小値になり、剰余は0になる
+			c := count()
+			fmt.Printf("  cmp   rdi, -1\n")
+			fmt.Printf("  jne   .L.idiv.%d\n", c)
+			fmt.Printf("  neg   rax\n")
+			fmt.Printf("  xor   edx, edx\n")
+			fmt.Printf("  jmp   .L.idiv.end.%d\n", c)
+			fmt.Printf(".L.idiv.%d:\n", c)
+			fmt.Printf("  cqo\n")
+			fmt.Printf("  idiv  rdi\n")
+			fmt.Printf(".L.idiv.end.%d:\n", c)
+		}
+		if kind == ND_MOD {
+			fmt.Printf("  mov   rax, rdx\n") // 剰余はrdxにセットされる
+		}
+	case ND_AND:
+		fmt.Printf("  and   rax, rdi\n")
+	case ND_OR:
+		fmt.Printf("  or    rax, rdi\n")
+	case ND_XOR:
+		fmt.Printf("  xor   rax, rdi\n")
+	case ND_ANDNOT:
+		fmt.Printf("  not   rdi\n")
+		fmt.Printf("  and   rax, rdi\n")
+	case ND_SHL:
+		// シフト回数が64以上なら0になる
+		fmt.Printf("  mov   rcx, rdi\n")
+		fmt.Printf("  shl   rax, cl\n")
+		fmt.Printf("  xor   edx, edx\n")
+		fmt.Printf("  cmp   rdi, 64\n")
+		fmt.Printf("  cmovae rax, rdx\n")
+	case ND_SHR:
+		if is_unsigned(ty) {
+			// 論理シフト シフト回数が64以上なら0になる
+			fmt.Printf("  mov   rcx, rdi\n")
+			fmt.Printf("  shr   rax, cl\n")
+			fmt.Printf("  xor   edx, edx\n")
+			fmt.Printf("  cmp   rdi, 64\n")
+			fmt.Printf("  cmovae rax, rdx\n")
+		} else {
+			// 算術シフト シフト回数が64以上なら符号ビットで埋める
+			fmt.Printf("  mov   ecx, 63\n")
+			fmt.Printf("  cmp   rdi, 63\n")
+			fmt.Printf("  cmovbe rcx, rdi\n")
+			fmt.Printf("  sar   rax, cl\n")
+		}
 	case ND_EQ:
 		fmt.Printf("  cmp   rax, rdi\n")
 		fmt.Printf("  sete  al\n")
@@ -86,31 +821,110 @@ func (cg *Codegen) gen_expr(node *Node) {
 		fmt.Printf("  movzb rax, al\n")
 	case ND_LT:
 		fmt.Printf("  cmp   rax, rdi\n")
-		fmt.Printf("  setl al\n")
+		if is_unsigned(ty) {
+			fmt.Printf("  setb  al\n")
+		} else {
+			fmt.Printf("  setl  al\n")
+		}
 		fmt.Printf("  movzb rax, al\n")
 	case ND_LE:
 		fmt.Printf("  cmp   rax, rdi\n")
-		fmt.Printf("  setle al\n")
+		if is_unsigned(ty) {
+			fmt.Printf("  setbe al\n")
+		} else {
+			fmt.Printf("  setle al\n")
+		}
 		fmt.Printf("  movzb rax, al\n")
 	default:
 		panic("コード生成できません")
 	}
-	fmt.Printf("  push  rax\n") // 計算した値をスタックに積む
+
+	switch kind {
+	case ND_ADD, ND_SUB, ND_MUL, ND_DIV, ND_SHL, ND_XOR:
+		cg.truncate(ty) // 演算結果を型の大きさに切り詰める
+	}
+}
+
+// 左辺のアドレスと右辺の値をすべて計算してから、左から順に代入する
+// 右辺が複数の値を返す関数呼び出しのときはその結果を順に代入する
+func (cg *Codegen) gen_assign(lhs []*Node, rhs []*Node) {
+	for _, node := range lhs {
+		cg.gen_addr(node) // 左辺のアドレスを計算してスタックに積み
+	}
+	for _, node := range rhs {
+		cg.gen_expr(node) // 右辺の式の値を計算してスタックに積む
+	}
+	n := len(lhs)
+	types := []*Type{}
+	for _, node := range lhs {
+		types = append(types, node.ty)
+	}
+	size := total_words(types) * 8 // 右辺の値の大きさ
+	offset := size                 // i番目の右辺の値の位置
+	for i, node := range lhs {
+		offset -= words(node.ty) * 8
+		fmt.Printf("  mov   rdi, [rsp+%d]\n", size+(n-1-i)*8) // i番目の左辺のアドレスをrdiにセットし
+		cg.store_value(node.ty, offset)                       // 変数にi番目の右辺の値を代入
+	}
+	cg.drop(size/8 + n) // スタックに積んだ値を捨てる
 }
 
 func (cg *Codegen) gen_stmt(node *Node) {
 	switch node.kind {
 	case ND_RETURN_STMT:
-		cg.gen_expr(node.lhs)                                   // 式の値を計算してスタックに積み
-		fmt.Printf("  pop   rax\n")                             // スタックからraxにポップし
-		fmt.Printf("  jmp   .L.return.%s\n", cg.current_fn.val) // リターンする
+		if len(node.args) > 0 {
+			cg.gen_assign(node.lhslist, node.args) // 結果の変数に値を代入し
+		}
+		fmt.Printf("  jmp   .L.return.%s\n", symbol(cg.current_fn)) // リターンする
+	case ND_DEFER_STMT:
+		// 呼び出す関数値と引数を記録したレコード(次のレコード, 関数値, スタックで渡すワード数, 引数)をリストの先頭に加える
+		// 引数はレジスタで渡す6ワード分を常に確保し、その後ろにスタックで渡す引数と結果の領域を置く
+		call := node.lhs
+		if call.callee == nil {
+			cg.gen_expr(call.lhs) // 関数値を評価しスタックに積む
+		}
+		for _, arg := range call.args {
+			cg.gen_expr(arg) // 引数を評価しスタックに積む
+		}
+		types := call_types(call)
+		stack := stack_args(types) + result_area(value_types([]*Node{call}))
+		ptrs := []int{0, 1} // 次のレコードへのポインタと関数値
+		for j, ty := range types {
+			for _, i := range ptrwords(ty) {
+				ptrs = append(ptrs, 3+total_words(types[:j])+i) // 引数はレコードの4ワード目から並ぶ
+			}
+		}
+		size := 24 + (len(argreg)+stack)*8
+		cg.gen_newobject(size, cg.typeinfo(size, ptrs))
+		fmt.Printf("  mov   QWORD PTR [rax+16], %d\n", stack)
+		dests := []string{}
+		for i := 0; i < total_words(types); i++ {
+			dests = append(dests, fmt.Sprintf("QWORD PTR [rax+%d]", 24+i*8))
+		}
+		cg.pop_values(dests, types)
+		if call.callee == nil {
+			// nilの関数値は、呼び出すとdefer文の位置でパニックする関数のクロージャに置き換える
+			c := count()
+			cg.pop("rdi")
+			fmt.Printf("  test  rdi, rdi\n")
+			fmt.Printf("  jne   .L.deferfn.%d\n", c)
+			fmt.Printf("  lea   rdi, [rip+%s]\n", cg.gen_nilfunc(node.token))
+			fmt.Printf(".L.deferfn.%d:\n", c)
+			fmt.Printf("  mov   [rax+8], rdi\n")
+		} else {
+			fmt.Printf("  lea   rdi, [rip+%s]\n", cg.funcval(symbol(call.callee)))
+			fmt.Printf("  mov   [rax+8], rdi\n")
+		}
+		fmt.Printf("  mov   rdi, [rbp-%d]\n", cg.current_fn.defers.offset)
+		fmt.Printf("  mov   [rax], rdi\n")
+		fmt.Printf("  mov   [rbp-%d], rax\n", cg.current_fn.defers.offset)
 	case ND_IF_STMT:
 		c := count()
 		if node.init != nil {
 			cg.gen_stmt(node.init) // init節があれば実行
 		}
 		cg.gen_expr(node.cond)                // condを計算してスタックに積み
-		fmt.Printf("  pop   rax\n")           // スタックからraxにポップし
+		cg.pop("rax")                         // スタックからraxにポップし
 		fmt.Printf("  cmp   rax, 0\n")        // 比較
 		fmt.Printf("  je    .L.else.%d\n", c) // condがfalseなら対応する.L.elseにジャンプ
 		cg.gen_stmt(node.then)                // then節を実行
@@ -128,11 +942,21 @@ func (cg *Codegen) gen_stmt(node *Node) {
 		fmt.Printf(".L.begin.%d:\n", c)
 		if node.cond != nil {
 			cg.gen_expr(node.cond)               // cond節があれば実行
-			fmt.Printf("  pop   rax\n")          // スタックからraxにポップし
+			cg.pop("rax")                        // スタックからraxにポップし
 			fmt.Printf("  cmp   rax, 0\n")       // 比較
 			fmt.Printf("  je    .L.end.%d\n", c) // condがfalseなら対応する.L.endにジャンプ
 		}
 		cg.gen_stmt(node.then)
+		fmt.Printf(".L.continue.%d:\n", c)
+		for _, variable := range node.loopvar {
+			// 次の繰り返し用の変数を割り当て、現在の値をコピーする
+			cg.gen_newobject(variable.ty.size, cg.type_info(variable.ty))
+			fmt.Printf("  mov   rsi, [rbp-%d]\n", variable.offset)
+			fmt.Printf("  mov   rdi, rax\n")
+			fmt.Printf("  mov   rcx, %d\n", variable.ty.size)
+			fmt.Printf("  rep movsb\n")
+			fmt.Printf("  mov   [rbp-%d], rax\n", variable.offset)
+		}
 		if node.inc != nil {
 			cg.gen_stmt(node.inc) // inc節があれば実行
 		}
@@ -143,27 +967,58 @@ func (cg *Codegen) gen_stmt(node *Node) {
 			cg.gen_stmt(stmt) // 文を逐次実行
 		}
 	case ND_EXPR_STMT:
-		cg.gen_expr(node.lhs)       // 式の値を計算してスタックに積み
-		fmt.Printf("  pop   rax\n") // スタックの値を捨てる
+		cg.gen_expr(node.lhs)                                // 式の値を計算してスタックに積み
+		cg.drop(total_words(value_types([]*Node{node.lhs}))) // スタックの値を捨てる
+	case ND_VARDECL:
+		for _, variable := range node.lvar {
+			if variable.heap {
+				cg.gen_newobject(variable.ty.size, cg.type_info(variable.ty)) // 変数の領域をヒープに割り当てる
+				fmt.Printf("  mov   [rbp-%d], rax\n", variable.offset)
+			}
+		}
+		cg.gen_assign(node.lhslist, node.rhslist)
 	case ND_ASSIGN_STMT:
-		cg.gen_addr(node.lhs)              // 左辺のアドレスを計算してスタックに積み
+		cg.gen_assign(node.lhslist, node.rhslist)
+	case ND_OPASSIGN_STMT:
+		cg.gen_addr(node.lhs)              // 左辺のアドレスを一度だけ計算してスタックに積み
+		fmt.Printf("  mov   rax, [rsp]\n") // 左辺のアドレスをraxにコピーし
+		cg.load(node.lhs.ty)               // 左辺の値を読み込み
+		cg.push("rax")                     // 左辺の値をスタックに積む
 		cg.gen_expr(node.rhs)              // 右辺の式の値を計算してスタックに積み
-		fmt.Printf("  pop   rdi\n")        // 式の値をrdiにポップし
-		fmt.Printf("  pop   rax\n")        // 変数のアドレスをraxにポップし
-		fmt.Printf("  mov   [rax], rdi\n") // 変数に値を代入
+		cg.pop("rdi")                      // 右辺の値をrdiにポップし
+		cg.pop("rax")                      // 左辺の値をraxにポップし
+		switch node.op {
+		case ND_SHL, ND_SHR:
+			cg.gen_shiftcheck(node)
+		case ND_DIV, ND_MOD:
+			cg.gen_divcheck(node)
+		}
+		cg.gen_binary(node.op, node.lhs.ty) // 演算して
+		cg.pop("rdi")                       // 左辺のアドレスをrdiにポップし
+		cg.store(node.lhs.ty)               // 変数に値を代入
 	case ND_EMPTY_STMT:
 		// 何もしない
 	default:
 		panic("コード生成できません")
 	}
+	if cg.depth != 0 {
+		panic("文の実行後にスタックに値が残っています")
+	}
 }
 
 func (cg *Codegen) codegen() {
 	fmt.Printf(".intel_syntax noprefix\n") //Intel記法
+	cg.types = map[string]string{}
+
+	cg.gen_entry()
+
 	for _, fn := range cg.program {
+		if fn.external {
+			continue // 外部の関数はリンクするときに解決する
+		}
 		cg.current_fn = fn
-		fmt.Printf(".global %s\n", fn.val)
-		fmt.Printf("%s:\n", fn.val)
+		name := symbol(fn)
+		fmt.Printf("%s:\n", name)
 
 		// プロローグ 特定のレジスタの値をスタックに退避(rbp, rsp, rbx, r12, r13, r14, r15)
 		// 関数呼び出しを行うときはRSPが16の倍数になっている状態でcall命令を呼ぶ必要がある。
@@ -174,16 +1029,101 @@ func (cg *Codegen) codegen() {
 		fmt.Printf("  push  r15\n")
 		fmt.Printf("  push  rbp\n")
 		fmt.Printf("  mov   rbp, rsp\n")
-		fmt.Printf("  sub   rsp, %d\n", fn.offset) // 変数用の領域を確保する
+		// 退避したレジスタとリターンアドレスで56バイト積んでいるので、8バイト余分に確保してrspを16の倍数にする
+		fmt.Printf("  sub   rsp, %d\n", fn.offset+8) // 変数用の領域を確保する
+		cg.depth = 0
 
 		// コード生成
-		for i, variable := range fn.params {
-			fmt.Printf("  mov   [rbp-%d], %s\n", variable.offset, argreg[i])
+		for i, variable := range fn.captures {
+			// 捕捉した変数のヒープ領域へのポインタをクロージャから読み出す
+			fmt.Printf("  mov   rax, [%s+%d]\n", ctxreg, 8+i*8)
+			fmt.Printf("  mov   [rbp-%d], rax\n", variable.offset)
+		}
+		j := 0
+		for _, variable := range fn.params {
+			offset := variable.offset
+			if variable.heap {
+				offset -= 8 // ヒープ領域へのポインタの下に受け取った値を置く
+			}
+			for i := 0; i < words(variable.ty); i++ {
+				if j < len(argreg) {
+					fmt.Printf("  mov   [rbp-%d], %s\n", offset-i*8, argreg[j])
+				} else {
+					// スタックで渡された引数はリターンアドレスと退避したレジスタの上にある
+					fmt.Printf("  mov   rax, [rbp+%d]\n", 56+(j-len(argreg))*8)
+					fmt.Printf("  mov   [rbp-%d], rax\n", offset-i*8)
+				}
+				j++
+			}
+		}
+		for _, variable := range fn.params {
+			if variable.heap {
+				// ヒープに割り当てた仮引数には受け取った値をコピーする
+				cg.gen_newobject(variable.ty.size, cg.type_info(variable.ty))
+				for i := 0; i < words(variable.ty); i++ {
+					fmt.Printf("  mov   rdi, [rbp-%d]\n", variable.offset-8-i*8)
+					fmt.Printf("  mov   [rax+%d], rdi\n", i*8)
+				}
+				fmt.Printf("  mov   [rbp-%d], rax\n", variable.offset)
+			}
+		}
+		for _, variable := range fn.results {
+			if variable.heap {
+				cg.gen_newobject(variable.ty.size, cg.type_info(variable.ty)) // ヒープに割り当てた領域はゼロ値で初期化されている
+				fmt.Printf("  mov   [rbp-%d], rax\n", variable.offset)
+				continue
+			}
+			for i := 0; i < words(variable.ty); i++ {
+				fmt.Printf("  mov   QWORD PTR [rbp-%d], 0\n", variable.offset-i*8) // 結果はゼロ値で初期化
+			}
+		}
+		if fn.defers != nil {
+			fmt.Printf("  mov   QWORD PTR [rbp-%d], 0\n", fn.defers.offset)
 		}
 		cg.gen_stmt(fn.body)
 
+		fmt.Printf(".L.return.%s:\n", name)
+		if fn.defers != nil {
+			// deferした関数を記録した逆順に呼び出す
+			fmt.Printf(".L.defer.%s:\n", name)
+			fmt.Printf("  mov   rax, [rbp-%d]\n", fn.defers.offset)
+			fmt.Printf("  test  rax, rax\n")
+			fmt.Printf("  je    .L.defer.end.%s\n", name)
+			fmt.Printf("  mov   rdi, [rax]\n")
+			fmt.Printf("  mov   [rbp-%d], rdi\n", fn.defers.offset)
+			// スタックで渡すワードをコピーする rspが16の倍数のままになるように偶数ワード分確保する
+			fmt.Printf("  mov   rbx, rsp\n")
+			fmt.Printf("  mov   rcx, [rax+16]\n")
+			fmt.Printf("  test  rcx, rcx\n")
+			fmt.Printf("  je    .L.defer.call.%s\n", name)
+			fmt.Printf("  lea   rdx, [rcx+1]\n")
+			fmt.Printf("  and   rdx, -2\n")
+			fmt.Printf("  shl   rdx, 3\n")
+			fmt.Printf("  sub   rsp, rdx\n")
+			fmt.Printf("  xor   edx, edx\n")
+			fmt.Printf(".L.defer.copy.%s:\n", name)
+			fmt.Printf("  mov   rdi, [rax+%d+rdx*8]\n", 24+len(argreg)*8)
+			fmt.Printf("  mov   [rsp+rdx*8], rdi\n")
+			fmt.Printf("  inc   rdx\n")
+			fmt.Printf("  cmp   rdx, rcx\n")
+			fmt.Printf("  jl    .L.defer.copy.%s\n", name)
+			fmt.Printf(".L.defer.call.%s:\n", name)
+			for i := len(argreg) - 1; i >= 0; i-- {
+				fmt.Printf("  mov   %s, [rax+%d]\n", argreg[i], 24+i*8) // 引数が少なければ使わない値が入る
+			}
+			fmt.Printf("  mov   %s, [rax+8]\n", ctxreg)
+			fmt.Printf("  mov   eax, 0\n")
+			fmt.Printf("  call  QWORD PTR [%s]\n", ctxreg)
+			fmt.Printf("  mov   rsp, rbx\n") // スタックで渡したワードを取り除く
+			fmt.Printf("  jmp   .L.defer.%s\n", name)
+			fmt.Printf(".L.defer.end.%s:\n", name)
+		}
+		for _, variable := range fn.results {
+			cg.gen_expr(&Node{kind: ND_VAR, variable: variable, ty: variable.ty}) // 結果の値をスタックに積み
+		}
+		cg.pop_values(result_dests(fn), fn.ty.results) // 結果のレジスタにセットする
+
 		// エピローグ スタックに退避した値をレジスタに戻す
-		fmt.Printf(".L.return.%s:\n", fn.val)
 		fmt.Printf("  mov   rsp, rbp\n")
 		fmt.Printf("  pop   rbp\n")
 		fmt.Printf("  pop   r15\n")
@@ -191,6 +1131,98 @@ func (cg *Codegen) codegen() {
 		fmt.Printf("  pop   r13\n")
 		fmt.Printf("  pop   r12\n")
 		fmt.Printf("  pop   rbx\n")
-		fmt.Printf("  ret\n") // 最後の式の結果がRAXに残っているのでそれがプログラムの返り値になる
+		fmt.Printf("  ret\n")
+	}
+	cg.gen_data()
+	fmt.Print(runtime_asm) // ランタイム
+	fmt.Print(gc_asm)      // ヒープとガベージコレクタ
+	fmt.Print(os_asm)      // osパッケージ
+}
+
+// プログラムの入口
+// コマンドライン引数と環境変数をランタイムに保存し、mainパッケージを初期化してからGoのmain関数を呼び出す
+// 戻ってきたら終了ステータス0で終了する
+func (cg *Codegen) gen_entry() {
+	if cg.freestanding {
+		// カーネルから直接制御を受け取る_start スタックの先頭にargc、その上にargvとenvpの配列が並んでいる
+		fmt.Printf(".global _start\n")
+		fmt.Printf("_start:\n")
+		fmt.Printf("  xor   ebp, ebp\n") // 最も外側のフレームであることを示す
+		fmt.Printf("  mov   rdi, [rsp]\n")
+		fmt.Printf("  lea   rsi, [rsp+8]\n")
+		fmt.Printf("  lea   rdx, [rsi+rdi*8+8]\n") // argvの終端のNULLの次からenvpが始まる
+		fmt.Printf("  and   rsp, -16\n")           // call命令を実行するときにrspが16の倍数になるように揃える
+	} else {
+		// libcのスタートアップルーチンから呼び出されるCのmain関数 argc, argv, envpを引数で受け取る
+		fmt.Printf(".global main\n")
+		fmt.Printf("main:\n")
+		fmt.Printf("  sub   rsp, 8\n") // call命令を実行するときにrspが16の倍数になるように揃える
+	}
+	fmt.Printf("  mov   [rip+runtime.argc], rdi\n")
+	fmt.Printf("  mov   [rip+runtime.argv], rsi\n")
+	fmt.Printf("  mov   [rip+runtime.envp], rdx\n")
+	fmt.Printf("  mov   [rip+runtime.stacktop], rsp\n") // ガベージコレクタはここからrspまでのスタックを走査する
+	fmt.Printf("  call  main.init\n")
+	fmt.Printf("  call  main.main\n")
+	if cg.freestanding {
+		fmt.Printf("  mov   edi, 0\n")
+		fmt.Printf("  call  runtime.exit\n")
+		return
+	}
+	// Cのmain関数から0を返し、libcのexitで標準入出力のバッファを書き出してから終了する
+	fmt.Printf("  add   rsp, 8\n")
+	fmt.Printf("  xor   eax, eax\n")
+	fmt.Printf("  ret\n")
+}
+
+// 整数の大きさごとの初期値のディレクティブ
+var data_directive = map[int]string{1: ".byte", 2: ".short", 4: ".long", 8: ".quad"}
+
+// パッケージレベルの変数の領域を確保する
+// 初期値が整数リテラルの変数は.dataに、それ以外は.bssに置き、.bssの変数はmain.initで初期化する
+func (cg *Codegen) gen_data() {
+	for _, decl := range cg.globals {
+		for i, lhs := range decl.lhslist {
+			if static_init(decl) {
+				fmt.Printf("  .data\n")
+				fmt.Printf("  .align 8\n")
+				fmt.Printf("%s:\n", lhs.variable.symbol)
+				fmt.Printf("  %s %d\n", data_directive[lhs.ty.size], immediate(decl.rhslist[i].num))
+			} else {
+				fmt.Printf("  .bss\n")
+				fmt.Printf("  .align 8\n")
+				fmt.Printf("%s:\n", lhs.variable.symbol)
+				fmt.Printf("  .zero %d\n", lhs.ty.size)
+			}
+		}
+	}
+
+	// ガベージコレクタのルートになるパッケージレベルの変数の中のポインタのワードのアドレスの表
+	// アドレスはリンク時に再配置するので.rodataではなく.data.rel.roに置く
+	fmt.Printf("  .section .data.rel.ro,\"aw\"\n")
+	fmt.Printf("  .align 8\n")
+	fmt.Printf("runtime.globalptrs:\n")
+	for _, decl := range cg.globals {
+		for _, lhs := range decl.lhslist {
+			for _, i := range ptrwords(lhs.ty) {
+				fmt.Printf("  .quad %s+%d\n", lhs.variable.symbol, i*8)
+			}
+		}
+	}
+	fmt.Printf("runtime.globalptrs.end:\n")
+	for _, fn := range cg.funcvals {
+		fmt.Printf("%s:\n", cg.funcval(fn))
+		fmt.Printf("  .quad %s\n", fn) // 捕捉した変数のないクロージャ
+	}
+	for _, itab := range cg.itabs {
+		fmt.Printf("%s:\n", itab.label)
+		for _, wrapper := range itab.wrappers {
+			fmt.Printf("  .quad %s\n", symbol(wrapper)) // インターフェースのメソッドの順に並べたラッパー関数
+		}
+	}
+	fmt.Printf("  .section .rodata\n")
+	for _, info := range cg.typeinfos {
+		fmt.Print(info) // 型情報 要素の大きさとポインタのワードのビットマップ
 	}
+	fmt.Printf("  .text\n")
 }
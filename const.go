@@ -0,0 +1,146 @@
+package main
+
+import "math/big"
+
+// 定数式の評価
+// 定数の値はmath/bigで任意精度で計算し、型なし定数は型を持つ値に変換するときに範囲を検査する。
+
+// シフト演算で左にずらせるビット数の上限
+const max_shift = 1024
+
+// 整数型tyで表せる値の範囲の最小値と最大値
+func int_range(ty *Type) (*big.Int, *big.Int) {
+	bits := uint(ty.size * 8)
+	one := big.NewInt(1)
+	if ty.unsigned {
+		max := new(big.Int).Lsh(one, bits)
+		return big.NewInt(0), max.Sub(max, one)
+	}
+	max := new(big.Int).Lsh(one, bits-1)
+	min := new(big.Int).Neg(max)
+	return min, max.Sub(max, one)
+}
+
+// 定数の値vが型tyで表せるかどうか 型なし定数はどんな整数も表せる
+func representable(v *big.Int, ty *Type) bool {
+	if ty.kind != TY_INT {
+		return true
+	}
+	min, max := int_range(ty)
+	return v.Cmp(min) >= 0 && v.Cmp(max) <= 0
+}
+
+// 定数nodeを型tyの値として使えることを検査する
+// 定数でない回数でシフトした型なし定数を含む式は、文脈の型tyの値として計算する
+// 型のないnilは型tyのゼロ値にする インターフェース型の値として使う具象型の値はインターフェースの値に変換する
+func (p *Parser) check_const(node *Node, ty *Type) {
+	if ty.kind == TY_INTERFACE && node.ty.kind != TY_INTERFACE && node.ty.kind != TY_NIL {
+		p.to_iface(node, ty)
+		return
+	}
+	if node.kind == ND_NUM {
+		if !representable(node.num, ty) {
+			error_tok(p.code, node.token, "定数%sが%s型をオーバーフローします", node.num, ty.name)
+		}
+		return
+	}
+	if node.ty.kind == TY_NIL && ty.kind != TY_NIL {
+		if !is_nillable(ty) {
+			error_tok(p.code, node.token, "nilを%s型の値として使えません", ty.name)
+		}
+		node.ty = ty
+	}
+	if node.ty.kind != TY_UNTYPED_INT || ty.kind != TY_INT {
+		return
+	}
+	node.ty = ty
+	switch node.kind {
+	case ND_SHL, ND_SHR, ND_BITNOT:
+		p.check_const(node.lhs, ty) // シフト回数の型は変わらない
+	case ND_ADD, ND_SUB, ND_MUL, ND_DIV, ND_MOD, ND_AND, ND_OR, ND_XOR, ND_ANDNOT:
+		p.check_const(node.lhs, ty)
+		p.check_const(node.rhs, ty)
+	}
+}
+
+// 真理値を定数の値にする
+func bool_value(b bool) *big.Int {
+	if b {
+		return big.NewInt(1)
+	}
+	return big.NewInt(0)
+}
+
+// 被演算子がすべて定数の演算を計算し、nodeをその値の定数に置き換える
+func (p *Parser) fold(node *Node) {
+	if node.lhs == nil || node.lhs.kind != ND_NUM || node.rhs != nil && node.rhs.kind != ND_NUM {
+		return
+	}
+	x := node.lhs.num
+	var y *big.Int
+	if node.rhs != nil {
+		y = node.rhs.num
+	}
+	v := new(big.Int)
+	switch node.kind {
+	case ND_ADD:
+		v.Add(x, y)
+	case ND_SUB:
+		v.Sub(x, y)
+	case ND_MUL:
+		v.Mul(x, y)
+	case ND_DIV, ND_MOD:
+		if y.Sign() == 0 {
+			error_tok(p.code, node.token, "ゼロで除算しています")
+		}
+		if node.kind == ND_DIV {
+			v.Quo(x, y) // Goの除算は0の方向に切り捨てる
+		} else {
+			v.Rem(x, y)
+		}
+	case ND_AND:
+		v.And(x, y)
+	case ND_OR:
+		v.Or(x, y)
+	case ND_XOR:
+		v.Xor(x, y)
+	case ND_ANDNOT:
+		v.AndNot(x, y)
+	case ND_SHL:
+		if y.Cmp(big.NewInt(max_shift)) > 0 {
+			error_tok(p.code, node.rhs.token, "シフト回数%sが大きすぎます", y)
+		}
+		v.Lsh(x, uint(y.Uint64()))
+	case ND_SHR:
+		n := uint(max_shift) // 十分大きくずらせば0か-1になる
+		if y.Cmp(big.NewInt(max_shift)) < 0 {
+			n = uint(y.Uint64())
+		}
+		v.Rsh(x, n) // 負の数は算術シフトになる
+	case ND_EQ:
+		v = bool_value(x.Cmp(y) == 0)
+	case ND_NE:
+		v = bool_value(x.Cmp(y) != 0)
+	case ND_LT:
+		v = bool_value(x.Cmp(y) < 0)
+	case ND_LE:
+		v = bool_value(x.Cmp(y) <= 0)
+	case ND_LOGAND:
+		v = bool_value(x.Sign() != 0 && y.Sign() != 0)
+	case ND_LOGOR:
+		v = bool_value(x.Sign() != 0 || y.Sign() != 0)
+	case ND_BITNOT:
+		if is_unsigned(node.ty) {
+			_, max := int_range(node.ty)
+			v.Xor(x, max) // 符号なし整数は型のビット数の範囲で反転する
+		} else {
+			v.Not(x)
+		}
+	default:
+		return
+	}
+	if !representable(v, node.ty) {
+		error_tok(p.code, node.token, "定数%sが%s型をオーバーフローします", v, node.ty.name)
+	}
+	*node = Node{kind: ND_NUM, token: node.token, ty: node.ty, num: v, val: v.String()}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// エスケープ解析
+// アドレスが関数のフレームより長く生きる可能性のあるローカル変数をヒープに割り当てる。
+// 関数ごとに値の流れをグラフにし、ヒープから逆にたどってアドレスが流れ込む変数を見つける。
+// 仮引数からヒープに流れ込むかどうかを記録しておき、呼び出し元では実引数がその仮引数を通じて流れ込むものとする。
+
+// 変数またはヒープに流れ込む値
+type Flow struct {
+	src    *Var
+	derefs int    // srcのアドレスなら-1、値なら0、値のポインタが指す先の値ならその参照の回数
+	reason string // ヒープに流れ込む場合、アドレスがどこに流れ込むか
+}
+
+type Escape struct {
+	flows map[*Var][]*Flow // 流れ込む先の変数ごとの値 ヒープに流れ込む値のキーはnil
+	leaks map[*Var]int     // ヒープに流れ込む仮引数とその参照の回数 全ての関数で共有する
+}
+
+// 式nodeの値のもとになっているローカル変数をderefs回参照した値として集める
+func (e *Escape) sources(node *Node, derefs int) []*Flow {
+	switch node.kind {
+	case ND_VAR:
+		if node.variable.symbol != "" {
+			return nil // パッケージレベルの変数は最初からフレームの外にある
+		}
+		return []*Flow{{src: node.variable, derefs: derefs}}
+	case ND_ADDR:
+		return e.sources(node.lhs, derefs-1)
+	case ND_DEREF, ND_INDEX:
+		return e.sources(node.lhs, derefs+1) // スライスの要素はスライスが指す配列の中にある
+	case ND_CONV:
+		return e.sources(node.lhs, derefs)
+	case ND_MEMBER:
+		if node.lhs.ty.kind == TY_PTR {
+			return e.sources(node.lhs, derefs+1)
+		}
+		return e.sources(node.lhs, derefs) // フィールドは構造体の値の中にある
+	case ND_IFACE:
+		if direct_iface(node.lhs.ty) {
+			return e.sources(node.lhs, derefs) // ポインタはそのままデータのワードになる
+		}
+	}
+	return nil // 関数呼び出しの結果やヒープに割り当てた領域はローカル変数を指さない
+}
+
+// 式srcの値が変数dstに流れ込む dstがnilならヒープに流れ込む
+func (e *Escape) flow(dst *Var, src *Node, reason string) {
+	for _, f := range e.sources(src, 0) {
+		f.reason = reason
+		e.flows[dst] = append(e.flows[dst], f)
+	}
+}
+
+// 代入の左辺lhsに式rhsの値が流れ込む
+func (e *Escape) assign(lhs *Node, rhs *Node) {
+	switch {
+	case lhs.kind == ND_VAR && lhs.variable.symbol != "":
+		e.flow(nil, rhs, fmt.Sprintf("パッケージレベルの変数%sに代入されます", lhs.variable.name))
+	case lhs.kind == ND_VAR && lhs.variable.capture != nil:
+		e.flow(nil, rhs, fmt.Sprintf("関数リテラルが捕捉した変数%sに代入されます", lhs.variable.name))
+	case lhs.kind == ND_VAR:
+		e.flow(lhs.variable, rhs, "")
+	case lhs.kind == ND_MEMBER && lhs.lhs.ty.kind != TY_PTR:
+		e.assign(lhs.lhs, rhs) // 構造体のフィールドへの代入は構造体への代入とみなす
+	case lhs.kind == ND_DEREF || lhs.kind == ND_INDEX || lhs.kind == ND_MEMBER:
+		e.flow(nil, rhs, "ポインタの指す先に代入されます") // どこを指しているかは追跡しない
+	}
+}
+
+// 文や式の中の値の流れを集める
+func (e *Escape) visit(node *Node) {
+	if node == nil {
+		return
+	}
+	switch node.kind {
+	case ND_VARDECL, ND_ASSIGN_STMT, ND_RETURN_STMT:
+		if len(node.lhslist) == len(node.rhslist) {
+			for i, lhs := range node.lhslist {
+				e.assign(lhs, node.rhslist[i])
+			}
+		}
+		if node.kind == ND_RETURN_STMT && len(node.lhslist) == len(node.args) {
+			for i, lhs := range node.lhslist {
+				e.assign(lhs, node.args[i]) // 返す値は結果の変数に代入する
+			}
+		}
+	case ND_FUNCCALL:
+		if node.lhs != nil && node.lhs.kind == ND_IMETHOD {
+			e.flow(nil, node.lhs.lhs, "インターフェースのメソッドの呼び出しに渡されます") // データのワードはレシーバになる
+		}
+		for i, arg := range node.args {
+			switch {
+			case node.lhs != nil && node.lhs.kind == ND_IMETHOD:
+				e.flow(nil, arg, "インターフェースのメソッドの呼び出しに渡されます")
+			case node.callee == nil:
+				e.flow(nil, arg, "関数値の呼び出しに渡されます") // 呼び出す関数は分からない
+			case node.callee.external:
+				e.flow(nil, arg, fmt.Sprintf("外部の関数%sに渡されます", node.callee.val))
+			case i < len(node.callee.params):
+				if derefs, ok := e.leaks[node.callee.params[i]]; ok {
+					for _, f := range e.sources(arg, derefs) {
+						f.reason = fmt.Sprintf("関数%sの引数からヒープに流れ込みます", node.callee.val)
+						e.flows[nil] = append(e.flows[nil], f)
+					}
+				}
+			}
+		}
+	case ND_SLICELIT:
+		for _, arg := range node.args {
+			e.flow(nil, arg, "可変長引数のスライスに格納されます")
+		}
+	case ND_IFACE:
+		if !direct_iface(node.lhs.ty) {
+			e.flow(nil, node.lhs, "インターフェースの値としてヒープにコピーされます")
+		}
+	}
+	for _, n := range []*Node{node.lhs, node.rhs, node.cond, node.then, node.els, node.init, node.inc} {
+		e.visit(n)
+	}
+	for _, list := range [][]*Node{node.lhslist, node.rhslist, node.block, node.args} {
+		for _, n := range list {
+			e.visit(n)
+		}
+	}
+}
+
+// 関数fnの変数のうちアドレスがヒープに流れ込むものをヒープに割り当てる
+// 仮引数がヒープに流れ込むかどうかが変わればtrueを返す
+func (e *Escape) analyze(fn *Node) bool {
+	e.flows = map[*Var][]*Flow{}
+	for _, result := range fn.results {
+		e.flows[nil] = append(e.flows[nil], &Flow{src: result, reason: "関数の結果として返されます"})
+	}
+	e.visit(fn.body)
+	vars := frame_vars(fn)
+	changed := false
+	roots := []*Var{nil}
+	escaped := map[*Var]bool{}
+	for _, variable := range vars {
+		if variable.escape != loopvar_escape && variable.escape != closure_escape {
+			variable.heap = false // 前回の解析の結果を消す
+			variable.escape = ""
+			continue
+		}
+		// 解析の前からヒープに割り当てると決まっている変数に流れ込む値もヒープに流れ込む
+		escaped[variable] = true
+		roots = append(roots, variable)
+		if old, ok := e.leaks[variable]; is_param(fn, variable) && (!ok || old > 0) {
+			e.leaks[variable] = 0
+			changed = true
+		}
+	}
+
+	for len(roots) > 0 {
+		root := roots[0]
+		roots = roots[1:]
+		derefs := map[*Var]int{root: 0}
+		reason := map[*Var]string{}
+		queue := []*Var{root}
+		for len(queue) > 0 {
+			dst := queue[0]
+			queue = queue[1:]
+			base := derefs[dst]
+			if base < 0 {
+				base = 0 // root = &x; x = yのときyのアドレスは流れ込まない
+			}
+			for _, f := range e.flows[dst] {
+				if escaped[f.src] {
+					continue
+				}
+				d := base + f.derefs
+				if old, ok := derefs[f.src]; ok && old <= d {
+					continue
+				}
+				derefs[f.src] = d
+				switch {
+				case dst == nil:
+					reason[f.src] = f.reason
+				case dst == root || derefs[dst] < 0:
+					reason[f.src] = fmt.Sprintf("ヒープに割り当てる変数%sに代入されます", dst.name)
+				default:
+					reason[f.src] = reason[dst]
+				}
+				queue = append(queue, f.src)
+			}
+		}
+		for _, variable := range vars {
+			d, ok := derefs[variable]
+			if !ok || variable == root {
+				continue
+			}
+			leak := d
+			if leak < 0 {
+				leak = 0
+			}
+			if old, ok := e.leaks[variable]; is_param(fn, variable) && (!ok || leak < old) {
+				e.leaks[variable] = leak // 仮引数の値がヒープに流れ込む
+				changed = true
+			}
+			if d < 0 && !escaped[variable] {
+				escaped[variable] = true
+				variable.heap = true
+				if variable.escape == "" {
+					variable.escape = "アドレスが" + reason[variable]
+				}
+				roots = append(roots, variable) // ヒープに割り当てた変数に流れ込む値もヒープに流れ込む
+			}
+		}
+	}
+	return changed
+}
+
+// 関数fnのフレームに置く変数 仮引数、結果、ローカル変数の順に並べる
+func frame_vars(fn *Node) []*Var {
+	vars := []*Var{}
+	vars = append(vars, fn.params...)
+	vars = append(vars, fn.results...)
+	return append(vars, fn.lvar...)
+}
+
+// variableが関数fnの仮引数かどうか
+func is_param(fn *Node, variable *Var) bool {
+	for _, param := range fn.params {
+		if param == variable {
+			return true
+		}
+	}
+	return false
+}
+
+// アドレスを取られたforのinit節の変数をヒープに割り当てる理由
+const loopvar_escape = "繰り返しごとに別の変数になるループ変数のアドレスが取られています"
+
+// 関数リテラルが捕捉した変数をヒープに割り当てる理由
+const closure_escape = "関数リテラルに捕捉されています"
+
+// 全ての関数のエスケープ解析を行う
+// 仮引数がヒープに流れ込むかどうかが呼び出し元に影響するので、変化がなくなるまで繰り返す
+func (p *Parser) escape(functions []*Node) {
+	e := &Escape{leaks: map[*Var]int{}}
+	for changed := true; changed; {
+		changed = false
+		for _, fn := range functions {
+			if !fn.external && e.analyze(fn) {
+				changed = true
+			}
+		}
+	}
+	if !p.report {
+		return
+	}
+	for _, fn := range functions {
+		for _, variable := range frame_vars(fn) {
+			if variable.heap {
+				fmt.Fprintf(os.Stderr, "[%d:%d] 変数%sをヒープに割り当てます(%s)\n", variable.token.line, variable.token.col, variable.name, variable.escape)
+			}
+		}
+	}
+}
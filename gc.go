@@ -0,0 +1,496 @@
+package main
+
+// ヒープの割り当てとマーク&スイープ方式のガベージコレクタ
+//
+// ヒープは起動後に最初に割り当てるときに予約する一つの連続した領域(アリーナ)で、ブロックを先頭から順に並べる。
+// ブロックは16バイトのヘッダと領域からなり、ヘッダには大きさとフラグ、領域の型情報のアドレスを置く。
+// 型情報は要素の大きさと、要素の64ワードごとに1ワードのポインタのワードのビットマップで、Codegenが型から作る。
+// ポインタを含まない領域の型情報は0。
+// 空きブロックはヘッダの2番目のワードで次の空きブロックを指すリストにつなぐ。
+// アリーナとは別にブロックの先頭の位置を16バイトごとに1ビットで表すビットマップを持ち、
+// 領域の途中を指すポインタからもブロックを見つけられるようにする。
+//
+// ルートはCodegenが出力するパッケージレベルの変数のポインタの表と、スタック全体。
+// スタックは型が分からないので、アリーナの中を指しているワードはすべてポインタとみなす(保守的なGC)。
+// 割り当て済みのバイト数が目標を超えたら回収し、次の目標は生きているバイト数のGOGC%増しにする。
+
+const gc_asm = `
+# runtime.newobject(size, type) ゼロ値で初期化したsizeバイトの領域をヒープから割り当てる typeは領域の型情報
+runtime.newobject:
+  add   rdi, 31 # ヘッダの16バイトを足して16の倍数に切り上げたブロックの大きさ
+  and   rdi, -16
+  cmp   QWORD PTR [rip+runtime.arena], 0
+  jne   runtime.newobject.trigger
+  push  rdi
+  push  rsi
+  call  runtime.heapinit
+  pop   rsi
+  pop   rdi
+runtime.newobject.trigger:
+  mov   rax, [rip+runtime.heapalloc]
+  add   rax, rdi
+  cmp   rax, [rip+runtime.nextgc]
+  jbe   runtime.newobject.find
+  cmp   QWORD PTR [rip+runtime.gcpercent], 0
+  jl    runtime.newobject.find # GOGC=offなら自動では回収しない
+  push  rdi
+  push  rsi
+  xor   edi, edi
+  call  runtime.gc
+  pop   rsi
+  pop   rdi
+runtime.newobject.find:
+  # 空きブロックのリストから最初に見つかった十分な大きさのブロックを使う rcxは前のブロックから次を指すワードのアドレス
+  lea   rcx, [rip+runtime.freelist]
+runtime.newobject.next:
+  mov   rax, [rcx]
+  test  rax, rax
+  je    runtime.newobject.bump
+  mov   rdx, [rax]
+  and   rdx, -16
+  cmp   rdx, rdi
+  jae   runtime.newobject.found
+  lea   rcx, [rax+8]
+  jmp   runtime.newobject.next
+runtime.newobject.found:
+  mov   r8, [rax+8]
+  sub   rdx, rdi
+  cmp   rdx, 32
+  jb    runtime.newobject.whole
+  # 残りが小さなブロックにもならないほど小さくなければ、残りを空きブロックとしてリストに残す
+  lea   r9, [rax+rdi]
+  or    rdx, 2
+  mov   [r9], rdx
+  mov   [r9+8], r8
+  mov   [rcx], r9
+  jmp   runtime.newobject.init
+runtime.newobject.whole:
+  add   rdi, rdx
+  mov   [rcx], r8
+  jmp   runtime.newobject.init
+runtime.newobject.bump:
+  # 空きブロックがなければアリーナの末尾から割り当てる
+  mov   rax, [rip+runtime.arenacur]
+  lea   rdx, [rax+rdi]
+  cmp   rdx, [rip+runtime.arenaend]
+  ja    runtime.newobject.oom
+  mov   [rip+runtime.arenacur], rdx
+runtime.newobject.init:
+  add   [rip+runtime.heapalloc], rdi
+  mov   [rax], rdi
+  mov   [rax+8], rsi
+  mov   rdx, rax
+  sub   rdx, [rip+runtime.arena]
+  shr   rdx, 4
+  mov   rcx, [rip+runtime.startbits]
+  bts   [rcx], rdx # ブロックの先頭の位置を記録する
+  lea   rdx, [rax+16]
+  lea   rcx, [rdi-16]
+  mov   rdi, rdx
+  xor   eax, eax
+  rep stosb # 回収したブロックには古い値が残っているので0で埋める
+  mov   rax, rdx
+  ret
+runtime.newobject.oom:
+  lea   rdi, [rip+runtime.msg.oom]
+  mov   esi, OFFSET runtime.msg.oom.end - runtime.msg.oom
+  jmp   runtime.fatal
+
+# runtime.heapinit() アリーナとビットマップ、マークスタックの領域を予約し、環境変数GOGCとGODEBUGを読む
+runtime.heapinit:
+  mov   rdi, 0x100000000 # アリーナは4GB
+  call  runtime.reserve
+  mov   [rip+runtime.arena], rax
+  mov   [rip+runtime.arenacur], rax
+  add   rax, rdi
+  mov   [rip+runtime.arenaend], rax
+  shr   rdi, 7 # 16バイトごとに1ビット
+  call  runtime.reserve
+  mov   [rip+runtime.startbits], rax
+  mov   rdi, 0x40000000 # ブロックは32バイト以上なので、全てのブロックのアドレスを積める大きさ
+  call  runtime.reserve
+  mov   [rip+runtime.markstack], rax
+  mov   [rip+runtime.marksp], rax
+  mov   QWORD PTR [rip+runtime.nextgc], 4194304 # 最初の目標は4MB
+  mov   QWORD PTR [rip+runtime.gcpercent], 100
+  lea   rdi, [rip+runtime.env.gogc]
+  mov   esi, OFFSET runtime.env.gogc.end - runtime.env.gogc
+  call  runtime.getenv
+  test  rax, rax
+  je    runtime.heapinit.gctrace
+  cmp   DWORD PTR [rax], 0x66666f # "off\0"
+  je    runtime.heapinit.off
+  xor   ecx, ecx
+runtime.heapinit.digit:
+  movzx edx, BYTE PTR [rax]
+  sub   edx, '0'
+  cmp   edx, 9
+  ja    runtime.heapinit.percent
+  imul  rcx, rcx, 10
+  add   rcx, rdx
+  inc   rax
+  jmp   runtime.heapinit.digit
+runtime.heapinit.off:
+  mov   rcx, -1
+runtime.heapinit.percent:
+  mov   [rip+runtime.gcpercent], rcx
+runtime.heapinit.gctrace:
+  lea   rdi, [rip+runtime.env.godebug]
+  mov   esi, OFFSET runtime.env.godebug.end - runtime.env.godebug
+  call  runtime.getenv
+  test  rax, rax
+  je    runtime.heapinit.end
+  lea   rsi, [rip+runtime.env.gctrace]
+  mov   ecx, OFFSET runtime.env.gctrace.end - runtime.env.gctrace
+  mov   rdi, rax
+  repe cmpsb
+  jne   runtime.heapinit.end
+  mov   QWORD PTR [rip+runtime.gctrace], 1
+runtime.heapinit.end:
+  ret
+
+# runtime.reserve(size) 物理メモリを確保せずにsizeバイトの領域を予約する 触れたページは0で埋められる
+runtime.reserve:
+  # mmap(NULL, size, PROT_READ|PROT_WRITE, MAP_PRIVATE|MAP_ANONYMOUS|MAP_NORESERVE, -1, 0)
+  push  rdi
+  mov   rsi, rdi
+  mov   eax, 9
+  xor   edi, edi
+  mov   edx, 3
+  mov   r10d, 0x4022
+  mov   r8, -1
+  xor   r9d, r9d
+  syscall
+  pop   rdi
+  cmp   rax, -4096
+  ja    runtime.newobject.oom
+  ret
+
+# runtime.getenv(name, len) "name=value"の形の環境変数を探し、valueのアドレスを返す なければ0
+runtime.getenv:
+  mov   r8, [rip+runtime.envp]
+runtime.getenv.next:
+  mov   rax, [r8]
+  test  rax, rax
+  je    runtime.getenv.end
+  add   r8, 8
+  mov   rdx, rdi
+  mov   rcx, rsi
+runtime.getenv.cmp:
+  mov   r9b, [rax]
+  cmp   r9b, [rdx]
+  jne   runtime.getenv.next
+  inc   rax
+  inc   rdx
+  dec   rcx
+  jne   runtime.getenv.cmp
+runtime.getenv.end:
+  ret
+
+# runtime.GC() ガベージコレクションを行う
+runtime.GC:
+  cmp   QWORD PTR [rip+runtime.arena], 0
+  je    runtime.GC.end # まだ何も割り当てていない
+  mov   edi, 1
+  jmp   runtime.gc
+runtime.GC.end:
+  ret
+
+# runtime.gc(forced) ルートから到達できるブロックに印を付け、印のないブロックを回収する
+# forcedはruntime.GC()で呼び出されたかどうかで、GODEBUG=gctrace=1のときの出力に使う
+runtime.gc:
+  push  rdi
+  push  QWORD PTR [rip+runtime.heapalloc]
+  inc   QWORD PTR [rip+runtime.numgc]
+  # パッケージレベルの変数のポインタをたどる
+  lea   rbx, [rip+runtime.globalptrs]
+runtime.gc.global:
+  lea   rax, [rip+runtime.globalptrs.end]
+  cmp   rbx, rax
+  jae   runtime.gc.stack
+  mov   rax, [rbx]
+  mov   rdi, [rax]
+  call  runtime.markptr
+  add   rbx, 8
+  jmp   runtime.gc.global
+runtime.gc.stack:
+  # スタックのワードはポインタかもしれないのですべてたどる
+  mov   rbx, rsp
+runtime.gc.stack.next:
+  cmp   rbx, [rip+runtime.stacktop]
+  jae   runtime.gc.sweep
+  mov   rdi, [rbx]
+  call  runtime.markptr
+  add   rbx, 8
+  jmp   runtime.gc.stack.next
+runtime.gc.sweep:
+  call  runtime.drain
+  call  runtime.sweep
+  # 次の目標は生きているバイト数のGOGC%増しで、4MBより小さくはしない GOGC=offなら変えない
+  mov   rax, [rip+runtime.gcpercent]
+  test  rax, rax
+  js    runtime.gc.trace
+  imul  rax, [rip+runtime.heapalloc]
+  mov   ecx, 100
+  xor   edx, edx
+  div   rcx
+  add   rax, [rip+runtime.heapalloc]
+  mov   ecx, 4194304
+  cmp   rax, rcx
+  cmovb rax, rcx
+  mov   [rip+runtime.nextgc], rax
+runtime.gc.trace:
+  cmp   QWORD PTR [rip+runtime.gctrace], 0
+  je    runtime.gc.end
+  # gc 回数: 回収前のバイト数->回収後のバイト数 B, 次の目標 B goal
+  lea   rdi, [rip+runtime.msg.gc]
+  mov   esi, OFFSET runtime.msg.gc.end - runtime.msg.gc
+  call  runtime.writeerr
+  mov   rdi, [rip+runtime.numgc]
+  call  runtime.writeint
+  lea   rdi, [rip+runtime.msg.gc.colon]
+  mov   esi, OFFSET runtime.msg.gc.colon.end - runtime.msg.gc.colon
+  call  runtime.writeerr
+  mov   rdi, [rsp]
+  call  runtime.writeint
+  lea   rdi, [rip+runtime.msg.gc.arrow]
+  mov   esi, OFFSET runtime.msg.gc.arrow.end - runtime.msg.gc.arrow
+  call  runtime.writeerr
+  mov   rdi, [rip+runtime.heapalloc]
+  call  runtime.writeint
+  lea   rdi, [rip+runtime.msg.gc.bytes]
+  mov   esi, OFFSET runtime.msg.gc.bytes.end - runtime.msg.gc.bytes
+  call  runtime.writeerr
+  mov   rdi, [rip+runtime.nextgc]
+  call  runtime.writeint
+  lea   rdi, [rip+runtime.msg.gc.goal]
+  mov   esi, OFFSET runtime.msg.gc.goal.end - runtime.msg.gc.goal
+  call  runtime.writeerr
+  cmp   QWORD PTR [rsp+8], 0
+  je    runtime.gc.newline
+  lea   rdi, [rip+runtime.msg.gc.forced]
+  mov   esi, OFFSET runtime.msg.gc.forced.end - runtime.msg.gc.forced
+  call  runtime.writeerr
+runtime.gc.newline:
+  mov   edi, '\n'
+  call  runtime.writebyte
+runtime.gc.end:
+  add   rsp, 16
+  ret
+
+# runtime.markptr(p) pがブロックの領域を指していれば、そのブロックに印を付けてマークスタックに積む
+runtime.markptr:
+  mov   rax, rdi
+  sub   rax, [rip+runtime.arena]
+  jb    runtime.markptr.end
+  cmp   rdi, [rip+runtime.arenacur]
+  jae   runtime.markptr.end
+  # pより前で最も近いブロックの先頭をビットマップから探す
+  shr   rax, 4
+  mov   rsi, [rip+runtime.startbits]
+  mov   rdx, rax
+  shr   rdx, 6
+  mov   ecx, eax
+  not   ecx
+  and   ecx, 63
+  mov   r8, [rsi+rdx*8]
+  shl   r8, cl # pより後ろのブロックのビットを捨てる
+  test  r8, r8
+  je    runtime.markptr.prev
+  bsr   r8, r8
+  sub   r8, rcx
+  jmp   runtime.markptr.found
+runtime.markptr.prev:
+  dec   rdx
+  js    runtime.markptr.end
+  mov   r8, [rsi+rdx*8]
+  test  r8, r8
+  je    runtime.markptr.prev
+  bsr   r8, r8
+runtime.markptr.found:
+  shl   rdx, 6
+  add   rdx, r8
+  shl   rdx, 4
+  add   rdx, [rip+runtime.arena]
+  mov   rax, [rdx]
+  test  al, 1
+  jne   runtime.markptr.end # 印を付け済み
+  mov   rcx, rax
+  and   rcx, -16
+  add   rcx, rdx
+  cmp   rdi, rcx
+  jae   runtime.markptr.end # ブロックの後ろの空き領域を指している
+  lea   rcx, [rdx+16]
+  cmp   rdi, rcx
+  jb    runtime.markptr.end # ヘッダを指している
+  or    rax, 1
+  mov   [rdx], rax
+  cmp   QWORD PTR [rdx+8], 0
+  je    runtime.markptr.end # ポインタを含まないブロックはたどらない
+  mov   rcx, [rip+runtime.marksp]
+  mov   [rcx], rdx
+  add   rcx, 8
+  mov   [rip+runtime.marksp], rcx
+runtime.markptr.end:
+  ret
+
+# runtime.drain() マークスタックが空になるまで、積んだブロックの中のポインタを型情報に従ってたどる
+runtime.drain:
+  mov   rcx, [rip+runtime.marksp]
+  cmp   rcx, [rip+runtime.markstack]
+  je    runtime.drain.end
+  sub   rcx, 8
+  mov   [rip+runtime.marksp], rcx
+  mov   rbx, [rcx]
+  mov   r12, [rbx+8] # 型情報
+  mov   r13, [rbx]
+  and   r13, -16
+  add   r13, rbx # ブロックの終わり
+  lea   r14, [rbx+16] # 要素の先頭
+runtime.drain.elem:
+  cmp   r14, r13
+  jae   runtime.drain
+  xor   ebx, ebx # ビットマップのワードが表す64ワードの要素の中でのオフセット
+runtime.drain.chunk:
+  mov   rax, rbx
+  shr   rax, 6
+  mov   r15, [r12+rax+8]
+runtime.drain.word:
+  test  r15, r15
+  je    runtime.drain.chunk.next
+  bsf   rax, r15
+  btr   r15, rax
+  lea   rax, [r14+rax*8]
+  add   rax, rbx
+  cmp   rax, r13
+  jae   runtime.drain.elem.next # 要素の途中でブロックが終わっている
+  mov   rdi, [rax]
+  call  runtime.markptr
+  jmp   runtime.drain.word
+runtime.drain.chunk.next:
+  add   rbx, 512
+  cmp   rbx, [r12]
+  jb    runtime.drain.chunk
+runtime.drain.elem.next:
+  add   r14, [r12]
+  jmp   runtime.drain.elem
+runtime.drain.end:
+  ret
+
+# runtime.sweep() アリーナを先頭から走査して印のないブロックを回収し、隣り合う空きブロックをまとめて空きブロックのリストを作り直す
+runtime.sweep:
+  mov   rbx, [rip+runtime.arena]
+  lea   r13, [rip+runtime.freelist] # 次の空きブロックを書き込むワードのアドレス
+  mov   QWORD PTR [r13], 0
+  mov   r12, r13 # 最後の空きブロックを指しているワードのアドレス
+  xor   r14d, r14d # 続いている空きブロックの先頭 なければ0
+  xor   r15d, r15d # 生きているブロックのバイト数
+runtime.sweep.block:
+  cmp   rbx, [rip+runtime.arenacur]
+  jae   runtime.sweep.end
+  mov   rax, [rbx]
+  mov   rcx, rax
+  and   rcx, -16
+  test  al, 2
+  jne   runtime.sweep.free
+  test  al, 1
+  je    runtime.sweep.garbage
+  and   rax, -2 # 印を消して次の回収に備える
+  mov   [rbx], rax
+  add   r15, rcx
+  xor   r14d, r14d
+  jmp   runtime.sweep.block.next
+runtime.sweep.garbage:
+  mov   rdx, rbx
+  sub   rdx, [rip+runtime.arena]
+  shr   rdx, 4
+  mov   rsi, [rip+runtime.startbits]
+  btr   [rsi], rdx
+runtime.sweep.free:
+  test  r14, r14
+  jne   runtime.sweep.merge
+  mov   r14, rbx
+  mov   rax, rcx
+  or    rax, 2
+  mov   [rbx], rax
+  mov   QWORD PTR [rbx+8], 0
+  mov   [r13], rbx
+  mov   r12, r13
+  lea   r13, [rbx+8]
+  jmp   runtime.sweep.block.next
+runtime.sweep.merge:
+  add   [r14], rcx
+runtime.sweep.block.next:
+  add   rbx, rcx
+  jmp   runtime.sweep.block
+runtime.sweep.end:
+  mov   [rip+runtime.heapalloc], r15
+  test  r14, r14
+  je    runtime.sweep.ret
+  # アリーナの末尾まで続いている空きブロックはリストから外し、アリーナの末尾を戻す
+  mov   QWORD PTR [r12], 0
+  mov   [rip+runtime.arenacur], r14
+runtime.sweep.ret:
+  ret
+
+.section .rodata
+runtime.msg.oom:
+  .ascii "fatal error: out of memory\n"
+runtime.msg.oom.end:
+runtime.msg.gc:
+  .ascii "gc "
+runtime.msg.gc.end:
+runtime.msg.gc.colon:
+  .ascii ": "
+runtime.msg.gc.colon.end:
+runtime.msg.gc.arrow:
+  .ascii "->"
+runtime.msg.gc.arrow.end:
+runtime.msg.gc.bytes:
+  .ascii " B, "
+runtime.msg.gc.bytes.end:
+runtime.msg.gc.goal:
+  .ascii " B goal"
+runtime.msg.gc.goal.end:
+runtime.msg.gc.forced:
+  .ascii " (forced)"
+runtime.msg.gc.forced.end:
+runtime.env.gogc:
+  .ascii "GOGC="
+runtime.env.gogc.end:
+runtime.env.godebug:
+  .ascii "GODEBUG="
+runtime.env.godebug.end:
+runtime.env.gctrace:
+  .ascii "gctrace=1\0"
+runtime.env.gctrace.end:
+.bss
+  .align 8
+runtime.arena:
+  .zero 8
+runtime.arenacur:
+  .zero 8
+runtime.arenaend:
+  .zero 8
+runtime.startbits:
+  .zero 8
+runtime.freelist:
+  .zero 8
+runtime.markstack:
+  .zero 8
+runtime.marksp:
+  .zero 8
+runtime.heapalloc:
+  .zero 8
+runtime.nextgc:
+  .zero 8
+runtime.gcpercent:
+  .zero 8
+runtime.gctrace:
+  .zero 8
+runtime.numgc:
+  .zero 8
+runtime.stacktop:
+  .zero 8
+.text
+`
@@ -0,0 +1,143 @@
+package main
+
+// パッケージレベルの変数の初期化順序
+// 変数は宣言順に初期化するが、初期化子が初期化されていない変数に依存していれば、その変数を先に初期化する。
+// 依存関係は初期化子が参照する変数と、参照する関数の本体が推移的に参照する変数からなる。
+
+// 初期化子や関数の本体が参照しているパッケージレベルの変数と関数の出現順のリスト
+type References struct {
+	vars  []*Var
+	funcs []*Node
+	seen  map[interface{}]bool
+}
+
+// ノード以下で参照しているパッケージレベルの変数と関数を集める
+func (r *References) collect(node *Node) {
+	if node == nil {
+		return
+	}
+	switch {
+	case node.kind == ND_VAR && node.variable.symbol != "" && !r.seen[node.variable]:
+		r.seen[node.variable] = true
+		r.vars = append(r.vars, node.variable)
+	case node.kind == ND_FUNCCALL && node.callee != nil && !node.callee.external && !r.seen[node.callee]:
+		r.seen[node.callee] = true
+		r.funcs = append(r.funcs, node.callee)
+	case (node.kind == ND_FUNCLIT || node.kind == ND_FUNCNAME) && !node.callee.external && !r.seen[node.callee]:
+		r.seen[node.callee] = true
+		r.funcs = append(r.funcs, node.callee) // 関数値にした関数の本体も呼び出される関数と同じようにたどる
+	case node.kind == ND_IFACE && !r.seen[node.itab]:
+		r.seen[node.itab] = true
+		r.funcs = append(r.funcs, node.itab.wrappers...) // メソッドの表から呼び出されうるメソッドもたどる
+	}
+	for _, n := range []*Node{node.lhs, node.rhs, node.cond, node.then, node.els, node.init, node.inc} {
+		r.collect(n)
+	}
+	for _, list := range [][]*Node{node.lhslist, node.rhslist, node.block, node.args} {
+		for _, n := range list {
+			r.collect(n)
+		}
+	}
+}
+
+// パッケージレベルの変数宣言の初期化子が依存している変数
+func dependencies(decl *Node) []*Var {
+	r := &References{seen: map[interface{}]bool{}}
+	for _, n := range decl.rhslist {
+		r.collect(n)
+	}
+	for i := 0; i < len(r.funcs); i++ {
+		r.collect(r.funcs[i].body) // 呼び出す関数の本体も推移的にたどる
+	}
+	return r.vars
+}
+
+// 初期化子がすべて整数リテラルの宣言は実行時に初期化せず、.dataに初期値を置く
+func static_init(decl *Node) bool {
+	for _, n := range decl.rhslist {
+		if n.kind != ND_NUM {
+			return false
+		}
+	}
+	return true
+}
+
+// 初期化子がすべてゼロ値の宣言は.bssに置くだけで初期化しない
+func zero_init(decl *Node) bool {
+	for _, n := range decl.rhslist {
+		if n.kind != ND_ZERO {
+			return false
+		}
+	}
+	return true
+}
+
+// パッケージの初期化ルーチンを作る
+// パッケージレベルの変数を初期化順に初期化してからinit関数を呼び出す 循環した依存関係があればエラー
+func (p *Parser) initFunc() *Node {
+	done := map[*Var]bool{} // 初期化済みの変数
+	deps := map[*GlobalDecl][]*Var{}
+	remaining := []*GlobalDecl{}
+	for _, decl := range p.globals {
+		if decl.constant {
+			continue
+		}
+		if zero_init(decl.node) || static_init(decl.node) {
+			for _, variable := range decl.vars {
+				done[variable] = true // 初期化子がなければゼロ値で、整数リテラルならその値で初期化済み
+			}
+			continue
+		}
+		deps[decl] = dependencies(decl.node)
+		remaining = append(remaining, decl)
+	}
+
+	body := &Node{kind: ND_BLOCK, block: []*Node{}}
+	for len(remaining) > 0 {
+		i := 0
+		for i < len(remaining) && !p.ready(deps[remaining[i]], done) {
+			i++
+		}
+		if i == len(remaining) {
+			p.cycleError(remaining[0], deps, done)
+		}
+		decl := remaining[i]
+		for _, variable := range decl.vars {
+			done[variable] = true
+		}
+		body.block = append(body.block, decl.node)
+		remaining = append(remaining[:i], remaining[i+1:]...)
+	}
+	for _, fn := range p.inits {
+		// 変数をすべて初期化してからinit関数を宣言順に呼び出す
+		call := &Node{kind: ND_FUNCCALL, token: fn.token, val: fn.val, callee: fn, ty: result_type(fn.ty)}
+		body.block = append(body.block, &Node{kind: ND_EXPR_STMT, lhs: call})
+	}
+	return &Node{kind: ND_FUNCDECL, val: "init", ty: func_type(nil, nil, false), body: body}
+}
+
+// 依存している変数がすべて初期化済みかどうか
+func (p *Parser) ready(deps []*Var, done map[*Var]bool) bool {
+	for _, variable := range deps {
+		if !done[variable] {
+			return false
+		}
+	}
+	return true
+}
+
+// 初期化されていない変数への依存をたどって循環している宣言を見つけ、エラーにする
+func (p *Parser) cycleError(decl *GlobalDecl, deps map[*GlobalDecl][]*Var, done map[*Var]bool) {
+	seen := map[*GlobalDecl]bool{}
+	for !seen[decl] {
+		seen[decl] = true
+		for _, variable := range deps[decl] {
+			if !done[variable] {
+				decl = p.globalDecl(variable)
+				break
+			}
+		}
+	}
+	token := decl.node.lhslist[0].token
+	error_tok(p.code, token, "変数%sの初期化が循環しています", token.val)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"strings"
@@ -25,19 +26,28 @@ func error_tok(code string, token *Token, fmtstr string, ap ...any) {
 }
 
 func main() {
-	if len(os.Args) != 2 {
+	// -runtime=freestanding ならlibcを使わず、ldだけでリンクできるプログラムを生成する
+	runtime := flag.String("runtime", "libc", "プログラムの入口を用意するランタイム(libcかfreestanding)")
+	// -m ならエスケープ解析でヒープに割り当てた変数とその理由を標準エラー出力に書き込む
+	report := flag.Bool("m", false, "ヒープに割り当てた変数とその理由を出力する")
+	flag.Parse()
+	if flag.NArg() != 1 {
 		fmt.Fprintln(os.Stderr, "引数の個数が正しくありません")
 		os.Exit(1)
 	}
+	if *runtime != "libc" && *runtime != "freestanding" {
+		fmt.Fprintf(os.Stderr, "ランタイム%sには対応していません\n", *runtime)
+		os.Exit(1)
+	}
 
-	code := os.Args[1]
+	code := flag.Arg(0)
 	if code[len(code)-1] != '\n' {
 		code += "\n" // ソースコードの終端が改行文字であることを保証
 	}
 	tokenizer := Tokenizer{code: code}
 	tokens := tokenizer.tokenize()
-	parser := Parser{code: code, tokens: tokens}
-	program := parser.parse()
-	codegen := Codegen{code: code, program: program}
+	parser := Parser{code: code, tokens: tokens, report: *report}
+	program, globals := parser.parse()
+	codegen := Codegen{code: code, program: program, globals: globals, freestanding: *runtime == "freestanding"}
 	codegen.codegen()
 }
@@ -0,0 +1,263 @@
+package main
+
+import "strconv"
+
+// メソッドと埋め込みフィールド、インターフェース
+// 埋め込んだフィールドのフィールドとメソッドは外側の型に昇格し、セレクタで外側の型の値から直接選べる。
+// インターフェースの値は具象型のメソッドを呼び出すラッパー関数の表とデータのワードの組で、メソッドは表を通じて呼び出す。
+
+// セレクタが選ぶフィールドかメソッド
+type Selection struct {
+	path    []*Field // 途中でたどる埋め込んだフィールド
+	field   *Field   // 構造体のフィールド
+	method  *Node    // 宣言されたメソッド
+	imethod *Field   // インターフェースのメソッド
+}
+
+// 型tyの値のセレクタnameが選ぶフィールドかメソッドと、最も浅い深さで見つかった個数を返す
+// 埋め込んだフィールドを浅い順にたどり、見つかった個数が2以上ならセレクタは曖昧になる
+// 同じ深さに同じ型が複数回現れればそれぞれを数え、より浅い深さで調べた型はもう一度は調べない
+func lookup_selector(ty *Type, name string) (*Selection, int) {
+	if ty.kind == TY_PTR && !ty.defined && ty.base.kind != TY_INTERFACE {
+		ty = ty.base // ポインタの指す値のフィールドとメソッドも選べる
+	}
+	type entry struct {
+		ty   *Type
+		path []*Field
+	}
+	current := []entry{{ty: ty}}
+	seen := map[*Type]bool{}
+	for len(current) > 0 {
+		var found *Selection
+		n := 0
+		next := []entry{}
+		for _, e := range current {
+			if seen[e.ty] {
+				continue
+			}
+			for _, m := range e.ty.methods {
+				if m.token.val == name {
+					found, n = &Selection{path: e.path, method: m}, n+1
+				}
+			}
+			for _, f := range e.ty.fields {
+				if f.name == name {
+					found, n = &Selection{path: e.path, field: f}, n+1
+					if e.ty.kind == TY_INTERFACE {
+						found.field, found.imethod = nil, f
+					}
+				}
+				if f.embedded {
+					et := f.ty
+					if et.kind == TY_PTR {
+						et = et.base
+					}
+					path := append(e.path[:len(e.path):len(e.path)], f)
+					next = append(next, entry{ty: et, path: path})
+				}
+			}
+		}
+		if n > 0 {
+			return found, n
+		}
+		for _, e := range current {
+			seen[e.ty] = true
+		}
+		current = next
+	}
+	return nil, 0
+}
+
+// メソッドfnの型からレシーバを除いた関数型
+func method_type(fn *Node) *Type {
+	return func_type(fn.ty.params[1:], fn.ty.results, fn.ty.variadic)
+}
+
+// 型tyのメソッド集合にセレクタselのメソッドが含まれるかどうか
+// ポインタレシーバのメソッドは、ポインタの値か、ポインタの埋め込みフィールドを通じて選んだときだけ含まれる
+func in_method_set(ty *Type, sel *Selection) bool {
+	if sel.method == nil {
+		return sel.imethod != nil
+	}
+	if sel.method.ty.params[0].kind != TY_PTR || ty.kind == TY_PTR {
+		return true
+	}
+	for _, f := range sel.path {
+		if f.ty.kind == TY_PTR {
+			return true
+		}
+	}
+	return false
+}
+
+// 型tyがインターフェース型ifaceのメソッドをすべて持つかどうか 埋め込んだフィールドから昇格したメソッドも含む
+func implements(ty *Type, iface *Type) bool {
+	for _, m := range iface.fields {
+		sel, n := lookup_selector(ty, m.name)
+		if n != 1 || !in_method_set(ty, sel) {
+			return false
+		}
+		var mty *Type
+		if sel.method != nil {
+			mty = method_type(sel.method)
+		} else {
+			mty = sel.imethod.ty
+		}
+		if !identical(mty, m.ty) {
+			return false
+		}
+	}
+	return true
+}
+
+// 具象型の値をインターフェース型の値に変換するときのメソッドの表
+type Itab struct {
+	label    string  // 表のラベル
+	concrete *Type   // 具象型
+	iface    *Type   // インターフェース型
+	wrappers []*Node // インターフェースのメソッドの順に並べたラッパー関数
+}
+
+// 具象型の値をデータのワードに直接入れるかどうか ポインタ以外の値はヒープにコピーしてそのポインタを入れる
+func direct_iface(ty *Type) bool {
+	return ty.kind == TY_PTR
+}
+
+// 具象型の値nodeをインターフェース型tyの値に変換するノードに置き換える
+func (p *Parser) to_iface(node *Node, ty *Type) {
+	value := *node
+	*node = Node{kind: ND_IFACE, token: node.token, ty: ty, lhs: &value, itab: p.itab(value.ty, ty)}
+}
+
+// 具象型concreteの値をインターフェース型ifaceの値に変換するときのメソッドの表
+// 同じ型の組の表は一つだけ作る
+func (p *Parser) itab(concrete *Type, iface *Type) *Itab {
+	for _, itab := range p.itabs {
+		if identical(itab.concrete, concrete) && identical(itab.iface, iface) {
+			return itab
+		}
+	}
+	n := strconv.Itoa(len(p.itabs) + 1)
+	itab := &Itab{label: "main.itab·" + n, concrete: concrete, iface: iface}
+	p.itabs = append(p.itabs, itab)
+	for _, m := range iface.fields {
+		itab.wrappers = append(itab.wrappers, p.wrapper(concrete, m, "itab·"+n+"."+m.name))
+	}
+	p.wrappers = append(p.wrappers, itab.wrappers...)
+	return itab
+}
+
+// 具象型concreteの値のメソッドmをインターフェースのメソッドの表から呼び出すラッパー関数を作る
+// ラッパー関数はデータのワードをレシーバとして受け取り、具象型のメソッドにレシーバと引数をそのまま渡す
+func (p *Parser) wrapper(concrete *Type, m *Field, name string) *Node {
+	fn := &Node{kind: ND_FUNCDECL, token: m.token, val: name}
+	recv := concrete
+	if !direct_iface(concrete) {
+		recv = pointer_to(concrete) // コピーしたヒープ領域へのポインタ
+	}
+	params := append([]*Type{recv}, m.ty.params...)
+	fn.ty = func_type(params, m.ty.results, m.ty.variadic)
+	outer := p.fn
+	p.fn = fn
+	fn.params = p.paramVars(make([]*Token, len(params)), params)
+	fn.results = p.paramVars(make([]*Token, len(m.ty.results)), m.ty.results)
+	p.fn = outer
+
+	x := &Node{kind: ND_VAR, token: m.token, variable: fn.params[0], ty: recv}
+	if !direct_iface(concrete) {
+		x = &Node{kind: ND_DEREF, token: m.token, lhs: x, ty: concrete}
+	}
+	sel, _ := lookup_selector(concrete, m.name)
+	call, recvArg, _ := p.methodCall(x, sel, m.token)
+	call.ty = result_type(m.ty)
+	call.args = []*Node{}
+	if recvArg != nil {
+		call.args = append(call.args, recvArg)
+	}
+	for _, param := range fn.params[1:] {
+		call.args = append(call.args, &Node{kind: ND_VAR, token: m.token, variable: param, ty: param.ty})
+	}
+	stmt := &Node{kind: ND_EXPR_STMT, lhs: call}
+	if len(fn.results) > 0 {
+		stmt = &Node{kind: ND_RETURN_STMT, token: m.token, args: []*Node{call}}
+		for _, result := range fn.results {
+			stmt.lhslist = append(stmt.lhslist, &Node{kind: ND_VAR, token: m.token, variable: result, ty: result.ty})
+		}
+	}
+	fn.body = &Node{kind: ND_BLOCK, block: []*Node{stmt}}
+	return fn
+}
+
+// 構造体の値またはそのポインタxのフィールドfを選ぶ
+func member(x *Node, f *Field, token *Token) *Node {
+	return &Node{kind: ND_MEMBER, token: token, val: f.name, lhs: x, ty: f.ty, field: f}
+}
+
+// 値xから埋め込んだフィールドのパスpathをたどる
+func embedded(x *Node, path []*Field, token *Token) *Node {
+	for _, f := range path {
+		x = member(x, f, token)
+	}
+	return x
+}
+
+// 値xのセレクタselのメソッドを呼び出す関数呼び出しと、最初の引数として渡すレシーバ、レシーバを除いたメソッドの型を返す
+// インターフェースのメソッドはレシーバをメソッドの表と一緒に渡すので、最初の引数のレシーバはnilになる
+// 引数は呼び出し元で加える
+func (p *Parser) methodCall(x *Node, sel *Selection, name *Token) (*Node, *Node, *Type) {
+	x = embedded(x, sel.path, name)
+	if sel.imethod != nil {
+		if x.ty.kind == TY_PTR {
+			x = &Node{kind: ND_DEREF, token: name, lhs: x, ty: x.ty.base}
+		}
+		method := &Node{kind: ND_IMETHOD, token: name, val: name.val, lhs: x, ty: sel.imethod.ty, offset: sel.imethod.offset}
+		return &Node{kind: ND_FUNCCALL, token: name, val: name.val, lhs: method}, nil, sel.imethod.ty
+	}
+	fn := sel.method
+	call := &Node{kind: ND_FUNCCALL, token: name, val: name.val, callee: fn}
+	return call, p.receiverArg(x, fn, name), method_type(fn)
+}
+
+// メソッドfnに値xをレシーバとして渡す式
+// ポインタレシーバには値のアドレスを、値レシーバにはポインタの指す値を渡す
+func (p *Parser) receiverArg(x *Node, fn *Node, name *Token) *Node {
+	pointer := fn.ty.params[0].kind == TY_PTR
+	switch {
+	case pointer && x.ty.kind != TY_PTR:
+		if !addressable(x) {
+			error_tok(p.code, name, "アドレスが取得できない値のポインタレシーバのメソッド%sは呼び出せません", name.val)
+		}
+		mark_addressed(x)
+		return &Node{kind: ND_ADDR, token: name, lhs: x, ty: pointer_to(x.ty)}
+	case !pointer && x.ty.kind == TY_PTR:
+		return &Node{kind: ND_DEREF, token: name, lhs: x, ty: x.ty.base}
+	}
+	return x
+}
+
+// Selector         = "." ident .
+// 値nodeのフィールドを選ぶか、メソッドを呼び出す
+// ポインタの指す構造体のフィールドも選べ、埋め込んだフィールドのフィールドとメソッドは外側の型に昇格する
+func (p *Parser) selector(node *Node, name *Token) *Node {
+	p.add_type(node)
+	p.check_value(node)
+	sel, n := lookup_selector(node.ty, name.val)
+	if n == 0 {
+		error_tok(p.code, name, "%s型には%sというフィールドもメソッドもありません", node.ty.name, name.val)
+	}
+	if n > 1 {
+		error_tok(p.code, name, "セレクタ%sが曖昧です", name.val)
+	}
+	if sel.field != nil {
+		return member(embedded(node, sel.path, name), sel.field, name)
+	}
+	if !p.startsWithValue("(") {
+		error_tok(p.code, name, "メソッド値には対応していません")
+	}
+	call, recv, mty := p.methodCall(node, sel, name)
+	p.arguments(call, mty)
+	if recv != nil {
+		call.args = append([]*Node{recv}, call.args...) // レシーバは最初の引数として渡す
+	}
+	return call
+}
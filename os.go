@@ -0,0 +1,26 @@
+package main
+
+// インポートできる標準パッケージ
+// 関数の本体はランタイムと同じくアセンブリで書き、"os.Exit"のようにパッケージ名で修飾したシンボルにする
+var std_packages = map[string]func() map[string]*Node{
+	"os":      os_package,
+	"runtime": runtime_package,
+}
+
+// パッケージpkgの関数nameの宣言
+func package_func(pkg string, name string, ty *Type) *Node {
+	return &Node{kind: ND_FUNCDECL, val: pkg + "." + name, ty: ty, external: true}
+}
+
+// osパッケージ
+func os_package() map[string]*Node {
+	return map[string]*Node{
+		"Exit": package_func("os", "Exit", func_type([]*Type{ty_int}, nil, false)),
+	}
+}
+
+const os_asm = `
+# os.Exit(code) 終了ステータスcodeで直ちにプログラムを終了する deferした関数は呼び出さない
+os.Exit:
+  jmp   runtime.exit
+`
@@ -1,34 +1,67 @@
 package main
 
+import (
+	"math/big"
+	"strconv"
+)
+
 type NodeKind int
 
 const (
-	ND_ADD         NodeKind = iota // +
-	ND_SUB                         // -
-	ND_MUL                         // *
-	ND_DIV                         // /
-	ND_EQ                          // ==
-	ND_NE                          // !=
-	ND_LT                          // <
-	ND_LE                          // <=
-	ND_ASSIGN_STMT                 // =
-	ND_ADDR                        // unary &
-	ND_DEREF                       // unary *
-	ND_RETURN_STMT                 // "return"
-	ND_IF_STMT                     // "if"
-	ND_FOR_STMT                    // "for"
-	ND_BLOCK                       // "{ ... }"
-	ND_FUNCCALL                    // Function call
-	ND_FUNCDECL                    // Function declaration
-	ND_EXPR_STMT                   // Expression statement
-	ND_EMPTY_STMT                  // Empty statement
-	ND_VAR                         // Variable
-	ND_NUM                         // Integer
+	ND_ADD           NodeKind = iota // +
+	ND_SUB                           // -
+	ND_MUL                           // *
+	ND_DIV                           // /
+	ND_MOD                           // %
+	ND_AND                           // &
+	ND_OR                            // |
+	ND_XOR                           // ^
+	ND_ANDNOT                        // &^
+	ND_SHL                           // <<
+	ND_SHR                           // >>
+	ND_EQ                            // ==
+	ND_NE                            // !=
+	ND_LT                            // <
+	ND_LE                            // <=
+	ND_LOGAND                        // &&
+	ND_LOGOR                         // ||
+	ND_BITNOT                        // unary ^
+	ND_ASSIGN_STMT                   // =
+	ND_OPASSIGN_STMT                 // op=, ++, --
+	ND_ADDR                          // unary &
+	ND_DEREF                         // unary *
+	ND_RETURN_STMT                   // "return"
+	ND_DEFER_STMT                    // "defer"
+	ND_IF_STMT                       // "if"
+	ND_FOR_STMT                      // "for"
+	ND_BLOCK                         // "{ ... }"
+	ND_FUNCCALL                      // Function call
+	ND_FUNCDECL                      // Function declaration
+	ND_VARDECL                       // Variable declaration
+	ND_EXPR_STMT                     // Expression statement
+	ND_EMPTY_STMT                    // Empty statement
+	ND_VAR                           // Variable
+	ND_NUM                           // Integer
+	ND_CONV                          // Type conversion
+	ND_INDEX                         // a[i]
+	ND_LEN                           // len(a)
+	ND_CAP                           // cap(a)
+	ND_PRINT                         // print(a, b) or println(a, b)
+	ND_NEW                           // new(T)
+	ND_SLICELIT                      // Slice created from variadic arguments
+	ND_PACK                          // Results of f(g()) packed for variadic parameter
+	ND_ZERO                          // Zero value
+	ND_FUNCLIT                       // Function literal
+	ND_FUNCNAME                      // Declared function
+	ND_MEMBER                        // Struct field
+	ND_IFACE                         // Conversion to interface
+	ND_IMETHOD                       // Interface method
 )
 
 type Node struct {
 	kind     NodeKind // Node kind
 	token    *Token   // Token
+	ty       *Type    // Type, e.g. int or pointer to int
 	lhs      *Node    // Left-hand side
 	rhs      *Node    // Right-hand side
 	cond     *Node    // Used if king == ND_IF_STMT or ND_FOR_STMT
@@ -36,28 +69,92 @@ type Node struct {
 	els      *Node    // Used if king == ND_IF_STMT
 	init     *Node    // Used if king == ND_FOR_STMT
 	inc      *Node    // Used if king == ND_FOR_STMT
+	loopvar  []*Var   // Used if king == ND_FOR_STMT
+	lhslist  []*Node  // Used if king == ND_VARDECL or ND_ASSIGN_STMT or ND_RETURN_STMT
+	rhslist  []*Node  // Used if king == ND_VARDECL or ND_ASSIGN_STMT
 	block    []*Node  // Used if king == ND_BLOCK
 	val      string   // Used if king == ND_NUM or ND_VAR or ND_FUNCCALL or ND_FUNCDECL
-	args     []*Node  // Used if king == ND_FUNCCALL
-	offset   int      // Used if king == ND_VAR or ND_FUNCDECL
-	params   []*Var   // Used if king == ND_FUNCCALL
+	args     []*Node  // Used if king == ND_FUNCCALL or ND_RETURN_STMT or ND_SLICELIT or ND_PRINT
+	callee   *Node    // Used if king == ND_FUNCCALL or ND_FUNCLIT or ND_FUNCNAME
+	offset   int      // Used if king == ND_VAR or ND_FUNCDECL or ND_IMETHOD
+	params   []*Var   // Used if king == ND_FUNCDECL
+	results  []*Var   // Used if king == ND_FUNCDECL
+	external bool     // Used if king == ND_FUNCDECL
+	defers   *Var     // Used if king == ND_FUNCDECL
 	body     *Node    // Used if king == ND_FUNCDECL
-	lvar     []*Var   // Used if king == ND_FUNCDECL
+	lvar     []*Var   // Used if king == ND_FUNCDECL or ND_VARDECL
+	captures []*Var   // Used if king == ND_FUNCDECL
+	outer    *Node    // Used if king == ND_FUNCDECL
 	variable *Var     // Used if king == ND_VAR
+	num      *big.Int // Used if king == ND_NUM
+	op       NodeKind // Used if king == ND_OPASSIGN_STMT
+	field    *Field   // Used if king == ND_MEMBER
+	itab     *Itab    // Used if king == ND_IFACE
+	recv     *Type    // Used if king == ND_FUNCDECL
 }
 
 type Var struct {
-	name   string
-	offset int
+	name      string
+	ty        *Type
+	offset    int
+	addressed bool   // アドレスが取られている
+	heap      bool   // ヒープに割り当てる(フレームのスロットにはヒープ領域へのポインタが入る)
+	escape    string // ヒープに割り当てる理由
+	token     *Token // 宣言した位置
+	symbol    string // パッケージレベルの変数のシンボル名 ローカル変数なら空文字列
+	constant  *Node  // 定数の値のND_NUM 変数ならnil
+	fn        *Node  // 宣言した関数 パッケージレベルの変数と定数ならnil
+	capture   *Var   // 関数リテラルが捕捉した外側の関数の変数 捕捉した変数でなければnil
+}
+
+// パッケージレベルの変数宣言(VarSpec)または定数宣言(ConstSpec) 関数の中の定数宣言にも使う
+type GlobalDecl struct {
+	start     int    // Specの先頭のトークンの位置
+	constant  bool   // 定数宣言かどうか
+	expr      int    // 定数宣言の型と式の位置 式を省略した場合は直前のConstSpecの型と式の位置
+	iota      int    // 定数宣言のiotaの値
+	vars      []*Var // 宣言する変数または定数
+	node      *Node  // 解析したND_VARDECL
+	resolved  bool   // 解析済み
+	resolving bool   // 初期化子を解析中
+}
+
+// パッケージレベルの型宣言(TypeSpec)
+type TypeDecl struct {
+	start     int   // 定義する型の位置
+	ty        *Type // 宣言した型 定義を解析するまでは名前だけを持つ
+	resolved  bool  // 解析済み
+	resolving bool  // 定義を解析中
+}
+
+// インポートしたパッケージ
+type Package struct {
+	name  string           // パッケージ名
+	token *Token           // インポート宣言のパスのトークン
+	funcs map[string]*Node // パッケージの関数
+	used  bool             // パッケージを参照している
 }
 
 type Parser struct {
-	code   string
-	tokens []*Token
-	i      int
-	scope  []map[string]*Var
-	lvar   []*Var
-	offset int
+	code     string
+	tokens   []*Token
+	i        int
+	scope    []map[string]*Var
+	lvar     []*Var
+	funcs    map[string]*Node     // パッケージで宣言された関数
+	inits    []*Node              // パッケージで宣言されたinit関数
+	imports  []*Package           // インポートしたパッケージ
+	globals  []*GlobalDecl        // パッケージレベルの変数宣言
+	types    map[string]*TypeDecl // パッケージで宣言された型
+	indirect int                  // 解析中の型がポインタ、スライス、関数の型の中にあれば正
+	itabs    []*Itab              // インターフェースのメソッドの表
+	wrappers []*Node              // メソッドの表から呼び出すラッパー関数
+	pending  []*Var               // 解析中のパッケージレベルの変数宣言で型を付ける変数
+	iota     int                  // 解析中の定数宣言のiotaの値 定数宣言の外では-1
+	fn       *Node                // 解析中の関数
+	funclit  []*Node              // 関数リテラルの関数
+	report   bool                 // -mフラグ ヒープに割り当てた変数とその理由を標準エラー出力に書き込む
+	offset   int
 }
 
 // Round up `n` to the nearest multiple of `align`. For instance,
@@ -122,62 +219,653 @@ func (p *Parser) leave_scope() {
 	p.scope = p.scope[1:] // スコープを抜ける
 }
 
-// program          = { FunctionDecl ";" } .
-func (p *Parser) parse() []*Node {
+// closeまでの";"で区切られた並びの要素をelemで解析し、closeを読み飛ばす 最後の";"は省略できる
+func (p *Parser) semicolonList(close string, elem func()) {
+	for !p.startsWithValue(close) {
+		elem()
+		if !p.startsWithValue(";") && !p.startsWithValue(close) {
+			error_tok(p.code, p.peek(1)[0], "セミコロンが見つかりません")
+		}
+		p.consumeIfPossible(";") // ";"があればスキップ
+	}
+	p.consume(close)
+}
+
+// program          = { ImportDecl ";" } { TopLevelDecl ";" } .
+// TopLevelDecl     = FunctionDecl | MethodDecl | TypeDecl | VarDecl | ConstDecl .
+// 関数とパッケージレベルの変数初期化の関数、パッケージレベルの変数宣言を返す
+func (p *Parser) parse() ([]*Node, []*Node) {
 	var functions []*Node
-	p.enter_scope() // ファイルスコープを追加
+	p.iota = -1
+	for p.startsWithValue("import") {
+		p.importDecl()
+		p.consume(";")
+	}
+	p.enter_scope() // パッケージスコープを追加
+	p.collectTypes()
+	p.collectDecls()
+	for _, decl := range p.globals {
+		p.resolveGlobal(decl, nil) // 関数の本体より先にパッケージレベルの変数と定数の型を決める
+	}
 	for !p.startsWithTokenKind(TK_EOF) {
-		fn := p.funcDecl()
-		functions = append(functions, fn)
+		switch {
+		case p.startsWithValue("var") || p.startsWithValue("const"):
+			p.skipDecl() // collectDeclsで登録し、resolveGlobalで解析済み
+		case p.startsWithValue("type"):
+			p.skipTypeDecl() // collectTypesで解析済み
+		default:
+			functions = append(functions, p.funcDecl())
+		}
 		p.consume(";")
 	}
-	p.leave_scope() // ファイルスコープを削除
-	return functions
+	p.leave_scope() // パッケージスコープを削除
+	for _, pkg := range p.imports {
+		if !pkg.used {
+			error_tok(p.code, pkg.token, "パッケージ%sをインポートしていますが使っていません", pkg.name)
+		}
+	}
+	globals := []*Node{}
+	for _, decl := range p.globals {
+		if !decl.constant {
+			globals = append(globals, decl.node)
+		}
+	}
+	functions = append(functions, p.initFunc())
+	functions = append(functions, p.funclit...)
+	functions = append(functions, p.wrappers...)
+	p.escape(functions)
+	for _, fn := range functions {
+		frame_layout(fn)
+	}
+	return functions, globals
 }
 
-// FunctionDecl     = "func" ident Parameters [ "int" ] Block .
-// Parameters       = "(" [ ident "int" { "," ident "int" } [ "," ] ] ")" .
-func (p *Parser) funcDecl() *Node {
-	p.enter_scope()    // スコープを追加
-	p.lvar = []*Var{}  // 関数のローカル変数のリスト
-	params := []*Var{} // 仮引数のリスト
+// ImportDecl       = "import" ( ImportSpec | "(" { ImportSpec ";" } ")" ) .
+func (p *Parser) importDecl() {
+	p.consume("import")
+	if p.consumeIfPossible("(") == nil {
+		p.importSpec()
+		return
+	}
+	p.semicolonList(")", p.importSpec)
+}
 
+// ImportSpec       = string_lit .
+// インポートできるのは標準パッケージだけで、パッケージ名はパスと同じ
+func (p *Parser) importSpec() {
+	token := p.consumeWithTokenKind(TK_STR)
+	funcs, ok := std_packages[token.val]
+	if !ok {
+		error_tok(p.code, token, "パッケージ%sが見つかりません", token.val)
+	}
+	if p.imported(token.val) != nil {
+		error_tok(p.code, token, "パッケージ%sはインポート済みです", token.val)
+	}
+	p.imports = append(p.imports, &Package{name: token.val, token: token, funcs: funcs()})
+}
+
+// インポートした名前nameのパッケージ インポートしていなければnil
+func (p *Parser) imported(name string) *Package {
+	for _, pkg := range p.imports {
+		if pkg.name == name {
+			return pkg
+		}
+	}
+	return nil
+}
+
+// 関数名とシグネチャを解析し、関数のノードと仮引数の名前と結果の名前を返す
+// メソッドはレシーバを最初の仮引数とする"T.M"という名前の関数になる
+func (p *Parser) signature() (*Node, []*Token, []*Token) {
 	p.consume("func")
+	var recvName *Token
+	var recv, base *Type
+	if p.startsWithValue("(") {
+		recvName, recv, base = p.receiver()
+	}
 	funcname := p.consumeWithTokenKind(TK_IDENT) // 関数名
+	fn := &Node{kind: ND_FUNCDECL, token: funcname, val: funcname.val, params: []*Var{}}
+	var names, resultNames []*Token
+	fn.ty, names, resultNames = p.signatureType()
+	if recv != nil {
+		fn.recv = base
+		fn.val = base.name + "." + funcname.val
+		fn.ty = func_type(append([]*Type{recv}, fn.ty.params...), fn.ty.results, fn.ty.variadic)
+		names = append([]*Token{recvName}, names...)
+	}
+	return fn, names, resultNames
+}
+
+// Receiver         = "(" [ ident ] [ "*" ] TypeName ")" .
+// レシーバの名前と型、レシーバの基になる型を返す 基になる型はパッケージで定義した型でなければならない
+func (p *Parser) receiver() (*Token, *Type, *Type) {
 	p.consume("(")
-	for !p.startsWithValue(")") {
-		param := p.consumeWithTokenKind(TK_IDENT) // 仮引数名
-		if _, ok := p.scope[0][param.val]; ok {
-			error_tok(p.code, param, "仮引数名が重複しています")
+	var name *Token
+	if p.startsWithTokenKind(TK_IDENT) && p.peek(2)[1].val != ")" {
+		name = p.read(1)[0]
+	}
+	star := p.consumeIfPossible("*")
+	token := p.consumeWithTokenKind(TK_IDENT)
+	base := p.typeName(token)
+	if !base.defined {
+		error_tok(p.code, token, "%s型はこのパッケージで定義した型ではありません", token.val)
+	}
+	if base.kind == TY_PTR || base.kind == TY_INTERFACE {
+		error_tok(p.code, token, "%s型にはメソッドを宣言できません", token.val)
+	}
+	p.consume(")")
+	if star != nil {
+		return name, pointer_to(base), base
+	}
+	return name, base, base
+}
+
+// Signature        = Parameters [ Result ] .
+// Result           = Parameters | Type .
+// シグネチャを解析し、関数型と仮引数の名前と結果の名前を返す
+func (p *Parser) signatureType() (*Type, []*Token, []*Token) {
+	names, params, variadic, cdots := p.parameters()
+	var resultNames []*Token
+	var results []*Type
+	switch {
+	case p.startsWithValue("("):
+		token := p.peek(1)[0]
+		var dots bool
+		var cdots *Token
+		resultNames, results, dots, cdots = p.parameters()
+		if dots || cdots != nil {
+			error_tok(p.code, token, "結果を可変長にはできません")
 		}
-		variable := &Var{name: param.val}    // 仮引数
-		params = append(params, variable)    // 変数リストに仮引数を追加
-		p.scope[0][variable.name] = variable // 現在のスコープに仮引数を追加
-		p.consume("int")                     // "int"をスキップ
-		if !p.startsWithValue(",") && !p.startsWithValue(")") {
-			error_tok(p.code, p.peek(1)[0], "不正なトークン")
+	case p.isTypeStart():
+		resultNames, results = []*Token{nil}, []*Type{p.typ()}
+	}
+	ty := func_type(params, results, variadic)
+	if cdots != nil {
+		if p.startsWithValue("{") {
+			error_tok(p.code, cdots, "Cの可変長引数は本体のない関数にしか使えません")
+		}
+		ty = c_variadic_type(ty)
+	}
+	return ty, names, resultNames
+}
+
+// 次のトークンが型の始まりかどうか
+func (p *Parser) isTypeStart() bool {
+	return p.startsWithTokenKind(TK_IDENT) || p.startsWithValue("*") || p.startsWithValue("[") || p.startsWithValue("func") ||
+		p.startsWithValue("struct") || p.startsWithValue("interface")
+}
+
+// 関数のシグネチャや変数の型より先に、パッケージで宣言されたすべての型を集めて定義を解析する
+// 定義には後で宣言された型も使えるので、型名をすべて登録してから解析する
+func (p *Parser) collectTypes() {
+	p.types = map[string]*TypeDecl{}
+	decls := []*TypeDecl{}
+	start := p.i
+	for !p.startsWithTokenKind(TK_EOF) {
+		switch {
+		case p.startsWithValue("import"):
+			error_tok(p.code, p.peek(1)[0], "インポート宣言は他の宣言より前に置かなければなりません")
+		case p.startsWithValue("type"):
+			for _, decl := range p.skipTypeDecl() {
+				name := decl.ty.name
+				if name == "_" {
+					continue // ブランク識別子の型は参照できないので定義も解析しない
+				}
+				if p.declared(name) {
+					error_tok(p.code, p.tokens[decl.start-1], "%sは宣言済みです", name)
+				}
+				p.types[name] = decl
+				decls = append(decls, decl)
+			}
+		case p.startsWithValue("var") || p.startsWithValue("const"):
+			p.skipDecl()
+		default:
+			p.skipFunc()
+		}
+		p.consume(";")
+	}
+	for _, decl := range decls {
+		p.resolveType(decl, nil)
+	}
+	p.i = start
+}
+
+// TypeDecl         = "type" ( TypeSpec | "(" { TypeSpec ";" } ")" ) .
+// TypeSpec         = ident Type .
+// 型宣言を読み飛ばし、各TypeSpecの型名と定義の位置を記録したTypeDeclを返す
+func (p *Parser) skipTypeDecl() []*TypeDecl {
+	p.consume("type")
+	if p.consumeIfPossible("(") == nil {
+		return []*TypeDecl{p.skipTypeSpec()}
+	}
+	decls := []*TypeDecl{}
+	p.semicolonList(")", func() {
+		decls = append(decls, p.skipTypeSpec())
+	})
+	return decls
+}
+
+// TypeSpecを読み飛ばす
+func (p *Parser) skipTypeSpec() *TypeDecl {
+	name := p.consumeWithTokenKind(TK_IDENT)
+	if p.startsWithValue("=") {
+		error_tok(p.code, p.peek(1)[0], "型の別名には対応していません")
+	}
+	decl := &TypeDecl{start: p.i, ty: &Type{name: name.val}}
+	p.skipToSpecEnd()
+	return decl
+}
+
+// 型宣言を解析し、宣言した型に定義を入れる
+// ポインタ、スライス、関数の型を通さずに自分自身を含む定義は循環しているのでエラーにする
+func (p *Parser) resolveType(decl *TypeDecl, token *Token) {
+	if decl.resolved {
+		return
+	}
+	if decl.resolving {
+		error_tok(p.code, token, "型%sの定義が循環しています", token.val)
+	}
+	decl.resolving = true
+	i, indirect := p.i, p.indirect
+	p.i, p.indirect = decl.start, 0
+	define(decl.ty, p.typ())
+	p.i, p.indirect = i, indirect
+	decl.resolving, decl.resolved = false, true
+}
+
+// 関数宣言を読み飛ばす シグネチャの中の構造体型とインターフェース型の"{"は本体の始まりと区別する
+func (p *Parser) skipFunc() {
+	p.consume("func")
+	for depth := 0; depth > 0 || !p.startsWithValue("{") && !p.startsWithValue(";"); {
+		switch {
+		case p.startsWithTokenKind(TK_EOF):
+			p.consume(";")
+		case p.startsWithValue("struct") || p.startsWithValue("interface"):
+			p.read(1)
+			p.skipBlock()
+			continue
+		case p.startsWithValue("(") || p.startsWithValue("["):
+			depth++
+		case p.startsWithValue(")") || p.startsWithValue("]"):
+			depth--
+		}
+		p.read(1)
+	}
+	if p.startsWithValue("{") {
+		p.skipBlock()
+	}
+}
+
+// 本体を解析する前にパッケージのすべての関数のシグネチャとパッケージレベルの変数と定数を集める
+// これにより宣言より前にある呼び出しや相互再帰の呼び出し、変数や定数の参照も検査できる
+func (p *Parser) collectDecls() {
+	p.funcs = map[string]*Node{}
+	start := p.i
+	for !p.startsWithTokenKind(TK_EOF) {
+		if p.startsWithValue("var") || p.startsWithValue("const") {
+			for _, decl := range p.skipDecl() {
+				p.collectSpec(decl)
+			}
+			p.consume(";")
+			continue
+		}
+		if p.startsWithValue("type") {
+			p.skipTypeDecl() // collectTypesで登録済み
+			p.consume(";")
+			continue
+		}
+		fn, _, _ := p.signature()
+		fn.external = !p.startsWithValue("{")
+		if fn.recv != nil {
+			p.collectMethod(fn)
+		} else if fn.val == "init" {
+			p.collectInit(fn)
+		} else {
+			if _, ok := p.funcs[fn.val]; ok || p.declared(fn.val) {
+				error_tok(p.code, fn.token, "%sは宣言済みです", fn.val)
+			}
+			p.funcs[fn.val] = fn
+		}
+		if !fn.external {
+			p.skipBlock()
+		}
+		p.consume(";")
+	}
+	main, ok := p.funcs["main"]
+	if !ok {
+		error_tok(p.code, p.peek(1)[0], "関数mainが宣言されていません")
+	}
+	p.checkEntryFunc(main)
+	p.i = start
+}
+
+// mainやinitのようにランタイムから呼び出す関数は引数と結果を持たず、本体がなければならない
+func (p *Parser) checkEntryFunc(fn *Node) {
+	if len(fn.ty.params) > 0 || len(fn.ty.results) > 0 {
+		error_tok(p.code, fn.token, "関数%sは引数と結果を持てません", fn.token.val)
+	}
+	if fn.external {
+		error_tok(p.code, fn.token, "関数%sには本体が必要です", fn.token.val)
+	}
+}
+
+// init関数はいくつでも宣言でき、変数の初期化の後に宣言順に実行する
+// 名前で参照できないので、パッケージスコープには加えず"init.0"のように番号を付けて区別する
+func (p *Parser) collectInit(fn *Node) {
+	p.checkEntryFunc(fn)
+	fn.val = "init." + strconv.Itoa(len(p.inits))
+	p.inits = append(p.inits, fn)
+}
+
+// メソッドをレシーバの基になる型に加える
+// 同じ型のメソッドどうしや、メソッドと構造体のフィールドは同じ名前にできない
+func (p *Parser) collectMethod(fn *Node) {
+	base, name := fn.recv, fn.token.val
+	if fn.external {
+		error_tok(p.code, fn.token, "メソッド%sには本体が必要です", fn.val)
+	}
+	for _, m := range base.methods {
+		if m.token.val == name {
+			error_tok(p.code, fn.token, "メソッド%sは宣言済みです", fn.val)
+		}
+	}
+	if base.kind == TY_STRUCT {
+		for _, f := range base.fields {
+			if f.name == name {
+				error_tok(p.code, fn.token, "%s型には%sというフィールドがあります", base.name, name)
+			}
+		}
+	}
+	base.methods = append(base.methods, fn)
+}
+
+// パッケージスコープかファイルスコープで名前nameを宣言済みかどうか
+func (p *Parser) declared(name string) bool {
+	return p.scope[0][name] != nil || p.imported(name) != nil || p.types[name] != nil
+}
+
+// Specで宣言する変数または定数を作り、パッケージスコープに加える
+// 型は初期化子を解析するまで分からないのでresolveGlobalで決める
+func (p *Parser) collectSpec(decl *GlobalDecl) {
+	end := p.i
+	p.i = decl.start
+	for _, name := range p.identList() {
+		variable := &Var{name: name.val}
+		if !decl.constant {
+			variable.symbol = "main." + name.val
+		}
+		if name.val == "init" {
+			error_tok(p.code, name, "initは関数としてしか宣言できません")
+		}
+		if name.val == "_" {
+			// ブランク識別子の変数はスコープに加えず、別々の領域を割り当てる
+			variable.symbol = "main._." + strconv.Itoa(len(p.globals))
+		} else {
+			_, ok := p.funcs[name.val]
+			if ok || p.declared(name.val) {
+				error_tok(p.code, name, "%sは宣言済みです", name.val)
+			}
+			p.scope[0][name.val] = variable
+		}
+		decl.vars = append(decl.vars, variable)
+	}
+	p.globals = append(p.globals, decl)
+	p.i = end
+}
+
+// VarDeclかConstDeclを読み飛ばし、各Specの位置を記録したGlobalDeclを返す
+func (p *Parser) skipDecl() []*GlobalDecl {
+	constant := p.read(1)[0].val == "const"
+	expr := -1 // 直前のConstSpecの型と式の位置
+	if p.consumeIfPossible("(") == nil {
+		return []*GlobalDecl{p.skipSpec(constant, &expr, 0)}
+	}
+	decls := []*GlobalDecl{}
+	p.semicolonList(")", func() {
+		decls = append(decls, p.skipSpec(constant, &expr, len(decls)))
+	})
+	return decls
+}
+
+// 括弧の外にある次の";"か")"の手前までSpecを読み飛ばす
+func (p *Parser) skipSpec(constant bool, expr *int, iota int) *GlobalDecl {
+	decl := &GlobalDecl{start: p.i, constant: constant, iota: iota}
+	names := p.identList()
+	if !p.startsWithValue(";") && !p.startsWithValue(")") {
+		*expr = p.i
+	}
+	if constant && *expr < 0 {
+		error_tok(p.code, names[len(names)-1], "定数の初期化子が必要です")
+	}
+	decl.expr = *expr
+	p.skipToSpecEnd()
+	return decl
+}
+
+// 括弧の外にある次の";"か")"の手前まで読み飛ばす
+func (p *Parser) skipToSpecEnd() {
+	for depth := 0; ; p.read(1) {
+		switch {
+		case p.startsWithTokenKind(TK_EOF):
+			p.consume(";")
+		case depth == 0 && (p.startsWithValue(";") || p.startsWithValue(")")):
+			return
+		case p.startsWithValue("(") || p.startsWithValue("[") || p.startsWithValue("{"):
+			depth++
+		case p.startsWithValue(")") || p.startsWithValue("]") || p.startsWithValue("}"):
+			depth--
 		}
-		p.consumeIfPossible(",") // ","があればスキップ
 	}
-	p.consume(")")             // ")"をスキップ
-	p.consumeIfPossible("int") // "int"があればスキップ
-	fn := &Node{kind: ND_FUNCDECL, token: funcname, val: funcname.val, body: p.block(), params: params, lvar: p.lvar}
+}
 
-	// 変数のオフセット計算
+// パッケージレベルの変数宣言や定数宣言、関数の中の定数宣言を解析し、変数や定数に型を付ける
+// 初期化子が型の決まっていない変数や定数を参照していれば、その宣言を先に解析する
+func (p *Parser) resolveGlobal(decl *GlobalDecl, token *Token) {
+	if decl.resolved {
+		return
+	}
+	if decl.resolving {
+		error_tok(p.code, token, "%sの初期化が循環しています", token.val)
+	}
+	decl.resolving = true
+	i, pending := p.i, p.pending
+	p.i = decl.start
+	if decl.constant {
+		names := p.identList()
+		for j, value := range p.constValues(names, decl.expr, decl.iota) {
+			decl.vars[j].ty, decl.vars[j].constant = value.ty, value
+		}
+	} else {
+		p.pending = decl.vars
+		decl.node = p.varSpec()
+	}
+	p.i, p.pending = i, pending
+	decl.resolving, decl.resolved = false, true
+}
+
+// 対応する"}"までのブロックを読み飛ばす
+func (p *Parser) skipBlock() {
+	p.consume("{")
+	for depth := 1; depth > 0; {
+		switch {
+		case p.startsWithTokenKind(TK_EOF):
+			p.consume("}")
+		case p.startsWithValue("{"):
+			depth++
+		case p.startsWithValue("}"):
+			depth--
+		}
+		p.read(1)
+	}
+}
+
+// FunctionDecl     = "func" ident Signature [ Block ] .
+// MethodDecl       = "func" Receiver ident Signature Block .
+// 本体のない関数宣言はCやアセンブリで定義した外部の関数を表す
+// 外部の関数でも"...T"の可変長引数はスライスで渡し、Cの可変長引数は型のない"..."で宣言する
+func (p *Parser) funcDecl() *Node {
+	p.enter_scope()   // スコープを追加
+	p.lvar = []*Var{} // 関数のローカル変数のリスト
+
+	sig, names, resultNames := p.signature()
+	fn := p.funcs[sig.val] // collectDeclsで登録した関数
+	if sig.val == "init" {
+		for _, init := range p.inits {
+			if init.token == sig.token {
+				fn = init
+			}
+		}
+	}
+	if sig.recv != nil {
+		for _, m := range sig.recv.methods {
+			if m.token == sig.token {
+				fn = m
+			}
+		}
+	}
+	p.fn = fn
+	fn.params = p.paramVars(names, fn.ty.params)
+	fn.results = p.paramVars(resultNames, fn.ty.results) // 結果もフレームの変数にする
+	if fn.external {
+		p.leave_scope()
+		return fn
+	}
+	fn.body = p.block()
+	fn.lvar = p.lvar
+	p.leave_scope() // スコープを削除
+	return fn
+}
+
+// 変数のオフセット計算
+// どの変数をヒープに割り当てるかはエスケープ解析で決まるので、全ての関数を解析してから行う
+func frame_layout(fn *Node) {
 	offset := 0
 	for _, variable := range fn.params {
-		offset += 8
+		offset += align_to(variable.ty.size, 8)
+		if variable.heap {
+			offset += 8 // 受け取った引数の値の上にヒープ領域へのポインタを置く
+		}
 		variable.offset = offset
 	}
-	for _, variable := range fn.lvar {
-		offset += 8
+	vars := append(append(fn.results, fn.captures...), fn.lvar...)
+	for _, variable := range vars {
+		if variable.heap {
+			offset += 8 // ヒープ領域へのポインタ
+		} else {
+			offset += align_to(variable.ty.size, 8)
+		}
 		variable.offset = offset
 	}
 
 	fn.offset = align_to(offset, 16) // 関数のオフセット計算
+}
 
-	p.leave_scope() // スコープを削除
-	return fn
+// 仮引数または結果の変数を作り、名前のあるものを現在のスコープに加える
+func (p *Parser) paramVars(names []*Token, types []*Type) []*Var {
+	vars := []*Var{}
+	for i, ty := range types {
+		variable := &Var{ty: ty, fn: p.fn} // 名前のない仮引数の名前は空文字列
+		vars = append(vars, variable)
+		if names[i] == nil {
+			continue
+		}
+		variable.name = names[i].val
+		variable.token = names[i]
+		if variable.name == "_" {
+			continue // ブランク識別子はスコープに加えない
+		}
+		if _, ok := p.scope[0][variable.name]; ok {
+			error_tok(p.code, names[i], "仮引数名が重複しています")
+		}
+		p.scope[0][variable.name] = variable // 現在のスコープに仮引数を追加
+	}
+	return vars
+}
+
+// Parameters       = "(" [ ParameterList [ "," [ "..." ] ] ] ")" .
+// ParameterList    = ParameterDecl { "," ParameterDecl } .
+// ParameterDecl    = [ IdentifierList ] [ "..." ] Type .
+// 仮引数の名前と型のリストと、可変長引数の関数かどうかを返す。名前のない仮引数の名前はnilになる。
+// 型のない最後の"..."はCの可変長引数を表し、そのトークンを返す。なければnilを返す。
+func (p *Parser) parameters() ([]*Token, []*Type, bool, *Token) {
+	var names []*Token
+	var types []*Type
+	var pending []*Token // 名前か型名かがまだ分からない識別子
+	named := false       // 名前付きの仮引数があるかどうか
+	var dots *Token      // 可変長引数の"..."
+	dotsIndex := 0       // 可変長引数の位置
+	var cdots *Token     // Cの可変長引数の"..."
+	p.consume("(")
+	for !p.startsWithValue(")") {
+		if dots != nil {
+			error_tok(p.code, dots, "可変長引数は最後の仮引数でなければなりません")
+		}
+		if cdots != nil {
+			error_tok(p.code, cdots, "Cの可変長引数は最後の仮引数でなければなりません")
+		}
+		switch {
+		case p.startsWithValue("...") && (p.peek(2)[1].val == ")" || p.peek(2)[1].val == ","):
+			cdots = p.consume("...")
+			if len(types) == 0 && len(pending) == 0 {
+				error_tok(p.code, cdots, "Cの可変長引数の前には仮引数が必要です")
+			}
+		case p.startsWithTokenKind(TK_IDENT) && (p.peek(2)[1].val == "," || p.peek(2)[1].val == ")"):
+			pending = append(pending, p.read(1)[0])
+		case p.startsWithTokenKind(TK_IDENT):
+			// 名前付きの仮引数 直前までの識別子も同じ型の仮引数の名前になる
+			if len(types) > len(names) {
+				error_tok(p.code, p.peek(1)[0], "名前付きの仮引数と名前のない仮引数が混在しています")
+			}
+			pending = append(pending, p.read(1)[0])
+			dotsIndex = len(types)
+			ty := p.paramType(&dots)
+			for _, name := range pending {
+				names = append(names, name)
+				types = append(types, ty)
+			}
+			pending = nil
+			named = true
+		default:
+			// 名前のない仮引数 直前までの識別子は型名になる
+			if named {
+				error_tok(p.code, p.peek(1)[0], "名前付きの仮引数と名前のない仮引数が混在しています")
+			}
+			for _, name := range pending {
+				types = append(types, p.typeName(name))
+			}
+			pending = nil
+			dotsIndex = len(types)
+			types = append(types, p.paramType(&dots))
+		}
+		if !p.startsWithValue(",") && !p.startsWithValue(")") {
+			error_tok(p.code, p.peek(1)[0], "不正なトークン")
+		}
+		p.consumeIfPossible(",") // ","があればスキップ
+	}
+	p.consume(")") // ")"をスキップ
+
+	if named && len(pending) > 0 {
+		error_tok(p.code, pending[0], "仮引数%sの型がありません", pending[0].val)
+	}
+	for _, name := range pending {
+		types = append(types, p.typeName(name))
+	}
+	if dots != nil && dotsIndex != len(types)-1 {
+		error_tok(p.code, dots, "可変長引数は最後の仮引数でなければなりません")
+	}
+	if !named {
+		names = make([]*Token, len(types))
+	}
+	return names, types, dots != nil, cdots
+}
+
+// 仮引数の型 "..."の付いた可変長引数の型はスライスになり、dotsに"..."をセットする
+func (p *Parser) paramType(dots **Token) *Type {
+	if p.startsWithValue("...") {
+		*dots = p.consume("...")
+		return slice_of(p.typ())
+	}
+	return p.typ()
 }
 
 // 以下構文規則
@@ -187,65 +875,248 @@ func (p *Parser) block() *Node {
 	p.enter_scope() // ブロックスコープを追加
 	p.consume("{")
 	node := &Node{kind: ND_BLOCK, block: []*Node{}}
-	for !p.startsWithValue("}") {
-		stmt := p.stmt()
-		node.block = append(node.block, stmt)
-		if !p.startsWithValue(";") && !p.startsWithValue("}") {
-			error_tok(p.code, p.peek(1)[0], "セミコロンが見つかりません")
-		}
-		p.consumeIfPossible(";") // ";"があればスキップ
-	}
-	p.consume("}")  // "}"をスキップ
+	p.semicolonList("}", func() {
+		node.block = append(node.block, p.stmt())
+	})
 	p.leave_scope() // ブロックスコープを削除
 	return node
 }
 
-// statement        = VarDecl | SimpleStmt | "return" expr | Block | IfStmt | forStmt .
+// statement        = VarDecl | ConstDecl | SimpleStmt | ReturnStmt | Block | IfStmt | forStmt .
 func (p *Parser) stmt() *Node {
 	switch {
 	case p.startsWithValue("var"): // VarDecl
 		return p.varDecl()
-	case p.startsWithValue("return"): // return statement
-		p.consume("return")
-		return &Node{kind: ND_RETURN_STMT, lhs: p.expr()}
+	case p.startsWithValue("const"): // ConstDecl
+		return p.constDecl()
+	case p.startsWithValue("return"): // ReturnStmt
+		return p.returnStmt()
+	case p.startsWithValue("defer"): // DeferStmt
+		return p.deferStmt()
 	case p.startsWithValue("{"): // block
 		return p.block()
 	case p.startsWithValue("if"): // IfStmt
 		return p.ifStmt()
 	case p.startsWithValue("for"): // ForStmt
 		return p.forStmt()
-	default: // simple statement
-		return p.simpleStmt()
+	case p.startsWithValue("type"):
+		error_tok(p.code, p.peek(1)[0], "関数の中の型宣言には対応していません")
+	}
+	return p.simpleStmt() // simple statement
+}
+
+// 現在のスコープに変数を宣言する
+func (p *Parser) declareVar(varname *Token, ty *Type) *Node {
+	if p.pending != nil {
+		// パッケージレベルの変数はcollectVarSpecで作ってあるので型を付けるだけ
+		variable := p.pending[0]
+		p.pending = p.pending[1:]
+		variable.ty = ty
+		return &Node{kind: ND_VAR, token: varname, val: varname.val, variable: variable, ty: ty}
+	}
+	variable := &Var{name: varname.val, ty: ty, token: varname, fn: p.fn}
+	p.lvar = append(p.lvar, variable)
+	if varname.val != "_" { // ブランク識別子はスコープに加えない
+		if _, ok := p.scope[0][varname.val]; ok {
+			// 変数が現在のスコープで宣言済みなのでエラー
+			error_tok(p.code, varname, "変数は宣言済みです。")
+		}
+		p.scope[0][variable.name] = variable
 	}
+	return &Node{kind: ND_VAR, token: varname, val: varname.val, variable: variable, ty: ty}
 }
 
-// VarDecl       = "var" ident ( "int" [ "=" expr ] | "=" expr ) .
+// IdentifierList   = ident { "," ident } .
+func (p *Parser) identList() []*Token {
+	idents := []*Token{p.consumeWithTokenKind(TK_IDENT)}
+	for p.consumeIfPossible(",") != nil {
+		idents = append(idents, p.consumeWithTokenKind(TK_IDENT))
+	}
+	return idents
+}
+
+// ExpressionList   = expr { "," expr } .
+func (p *Parser) exprList() []*Node {
+	exprs := []*Node{p.exprOrNil()}
+	if exprs[0] == nil {
+		error_tok(p.code, p.peek(1)[0], "式が必要です")
+	}
+	for p.consumeIfPossible(",") != nil {
+		exprs = append(exprs, p.expr())
+	}
+	if len(exprs) == 1 && exprs[0].ty.kind == TY_TUPLE && len(exprs[0].ty.results) > 0 {
+		return exprs // 複数の値を返す関数呼び出しは単独でリストにできる
+	}
+	p.check_value(exprs[0])
+	return exprs
+}
+
+// VarDecl          = "var" ( VarSpec | "(" { VarSpec ";" } ")" ) .
 func (p *Parser) varDecl() *Node {
 	p.consume("var")
+	if p.consumeIfPossible("(") == nil {
+		return p.varSpec()
+	}
+	node := &Node{kind: ND_BLOCK, block: []*Node{}}
+	p.semicolonList(")", func() {
+		node.block = append(node.block, p.varSpec())
+	})
+	return node
+}
 
-	varname := p.consumeWithTokenKind(TK_IDENT)
-	if _, ok := p.scope[0][varname.val]; ok {
-		// 変数が現在のスコープで宣言済みなのでエラー
-		error_tok(p.code, varname, "変数は宣言済みです。")
+// VarSpec          = IdentifierList ( Type [ "=" ExpressionList ] | "=" ExpressionList ) .
+func (p *Parser) varSpec() *Node {
+	varnames := p.identList()
+	if p.startsWithValue(";") || p.startsWithValue(")") {
+		error_tok(p.code, varnames[len(varnames)-1], "型名か初期化子が必要です。")
 	}
-	// 宣言されていないならスコープとローカル変数リストに加える。
-	variable := &Var{name: varname.val}
-	p.scope[0][variable.name] = variable
-	p.lvar = append(p.lvar, variable)
 
-	lhs := &Node{kind: ND_VAR, token: varname, val: varname.val, variable: variable}
+	var ty *Type
+	if !p.startsWithValue("=") {
+		ty = p.typ()
+	}
+	node := &Node{kind: ND_VARDECL, token: varnames[0]}
+	if p.startsWithValue("=") {
+		node.token = p.consume("=") // "="をスキップ
+		node.rhslist = p.exprList() // 右辺は変数を宣言する前に解析する
+	} else {
+		for _, varname := range varnames {
+			zero := &Node{kind: ND_ZERO, token: varname, ty: ty} // 宣言のみの場合はゼロ値で初期化
+			node.rhslist = append(node.rhslist, zero)
+		}
+	}
+	types := value_types(node.rhslist)
+	if len(types) != len(varnames) {
+		error_tok(p.code, node.token, "代入の個数が一致しません(%d個の変数に%d個の値)", len(varnames), len(types))
+	}
+	for i, varname := range varnames {
+		vty := ty
+		if vty == nil {
+			vty = default_type(types[i]) // 型を省略した場合は初期化子の型
+		}
+		lhs := p.declareVar(varname, vty)
+		node.lhslist = append(node.lhslist, lhs)
+		node.lvar = append(node.lvar, lhs.variable)
+	}
+	p.check_assign(node)
+	return node
+}
 
-	if p.startsWithValue(";") {
-		error_tok(p.code, lhs.token, "型名か初期化子が必要です。")
+// ConstDecl        = "const" ( ConstSpec | "(" { ConstSpec ";" } ")" ) .
+// ConstSpec        = IdentifierList [ [ Type ] "=" ExpressionList ] .
+// 関数の中の定数宣言もパッケージレベルと同じくskipDeclで読み飛ばしてからresolveGlobalで解析する
+// 定数のスコープはConstSpecの後ろから始まるので、値を決めてからスコープに加える
+func (p *Parser) constDecl() *Node {
+	node := &Node{kind: ND_EMPTY_STMT, token: p.peek(1)[0]}
+	for _, decl := range p.skipDecl() {
+		end := p.i
+		p.i = decl.start
+		names := p.identList()
+		p.i = end
+		for _, name := range names {
+			decl.vars = append(decl.vars, &Var{name: name.val})
+		}
+		p.resolveGlobal(decl, nil)
+		for i, name := range names {
+			if name.val == "_" {
+				continue // ブランク識別子はスコープに加えない
+			}
+			if _, ok := p.scope[0][name.val]; ok {
+				error_tok(p.code, name, "%sは宣言済みです", name.val)
+			}
+			p.scope[0][name.val] = decl.vars[i]
+		}
 	}
+	return node
+}
 
-	p.consumeIfPossible("int")
-	rhs := &Node{kind: ND_NUM, val: "0"} // 宣言のみの場合はゼロ値で初期化
-	if p.startsWithValue("=") {
-		p.consume("=") // "="をスキップ
-		rhs = p.expr()
+// 位置exprの[ Type ] "=" ExpressionListを解析し、定数namesの値のリストを返す
+// 式を省略したConstSpecでは直前のConstSpecの型と式をもう一度解析する
+func (p *Parser) constValues(names []*Token, expr int, iota int) []*Node {
+	start, saved := p.i, p.iota
+	p.i, p.iota = expr, iota
+	var ty *Type
+	if !p.startsWithValue("=") {
+		ty = p.typ()
+	}
+	token := p.consume("=")
+	values := p.exprList()
+	if expr != start {
+		p.i = start // 繰り返した型と式の後ろではなく、ConstSpecの後ろから解析を続ける
+	}
+	p.iota = saved
+	if len(values) != len(names) {
+		error_tok(p.code, token, "定数の個数が一致しません(%d個の定数に%d個の値)", len(names), len(values))
+	}
+	for i, value := range values {
+		if value.kind != ND_NUM {
+			error_tok(p.code, value.token, "定数%sの値が定数式ではありません", names[i].val)
+		}
+		if ty != nil {
+			if !assignable(value.ty, ty) {
+				error_tok(p.code, value.token, "%s型の値を%s型の定数にできません", value.ty.name, ty.name)
+			}
+			p.check_const(value, ty)
+			value.ty = ty // 型を指定した定数は型付きの定数になる
+		}
+	}
+	return values
+}
+
+// ReturnStmt       = "return" [ ExpressionList ] .
+func (p *Parser) returnStmt() *Node {
+	node := &Node{kind: ND_RETURN_STMT, token: p.consume("return")}
+	if !p.startsWithValue(";") && !p.startsWithValue("}") {
+		node.args = p.exprList()
+	}
+	results := p.fn.ty.results
+	if len(node.args) == 0 && len(results) > 0 && p.fn.results[0].name != "" {
+		// 結果に名前があれば式のないreturnでその値を返す
+		for _, result := range p.fn.results {
+			if result.name != "_" && p.lookup(result.name) != result {
+				error_tok(p.code, node.token, "結果%sがシャドウされているので値を省略できません", result.name)
+			}
+		}
+		return node
+	}
+	for _, result := range p.fn.results {
+		lhs := &Node{kind: ND_VAR, token: node.token, val: result.name, variable: result, ty: result.ty}
+		node.lhslist = append(node.lhslist, lhs) // 返す値は結果の変数に代入する
+	}
+	types := value_types(node.args)
+	if len(types) != len(results) {
+		error_tok(p.code, node.token, "返り値の個数が一致しません(%d個の結果に%d個の値)", len(results), len(types))
 	}
-	return &Node{kind: ND_ASSIGN_STMT, lhs: lhs, rhs: rhs}
+	for i, ty := range types {
+		if !assignable(ty, results[i]) {
+			arg := node.args[0] // 関数呼び出しの結果を返す場合はその呼び出しの位置
+			if len(node.args) == len(types) {
+				arg = node.args[i]
+			}
+			error_tok(p.code, arg.token, "%s型の値を%s型の結果として返せません", ty.name, results[i].name)
+		}
+		if len(node.args) == len(types) {
+			p.check_const(node.args[i], results[i])
+		} else {
+			p.check_tuple_value(node.args[0].token, ty, results[i])
+		}
+	}
+	return node
+}
+
+// DeferStmt        = "defer" expr .
+// deferした関数呼び出しの引数はその場で評価し、呼び出しは関数から戻る直前に逆順に行う
+func (p *Parser) deferStmt() *Node {
+	node := &Node{kind: ND_DEFER_STMT, token: p.consume("defer")}
+	node.lhs = p.exprOrNil()
+	if node.lhs == nil || node.lhs.kind != ND_FUNCCALL {
+		error_tok(p.code, node.token, "deferには関数呼び出しが必要です")
+	}
+	if p.fn.defers == nil {
+		p.fn.defers = &Var{ty: ty_uintptr} // deferした関数呼び出しのリストの先頭を指す変数
+		p.lvar = append(p.lvar, p.fn.defers)
+	}
+	return node
 }
 
 // IfStmt           = "if" [ SimpleStmt ";" ] expr Block [ "else" ( IfStmt | Block ) ] .
@@ -264,6 +1135,7 @@ func (p *Parser) ifStmt() *Node {
 		// 初期化子なし
 		node.cond = condOrInit.lhs
 	}
+	p.check_cond(node.cond)
 	node.then = p.block()
 	if p.startsWithValue("else") {
 		p.consume("else") // "else"をスキップ
@@ -288,142 +1160,349 @@ func (p *Parser) forStmt() *Node {
 	case p.startsWithValue("{") && condOrInit.kind == ND_EMPTY_STMT: // pattern 1: for {}
 	case p.startsWithValue("{") && condOrInit.kind != ND_EMPTY_STMT: // pattern 2: for cond {}
 		node.cond = condOrInit.lhs
+		p.check_cond(node.cond)
 	case p.startsWithValue(";"): // pattern 3: for init?; cond?; inc? {}
 		node.init = condOrInit
 		p.consume(";")
 		node.cond = p.exprOrNil()
+		if node.cond != nil {
+			p.check_cond(node.cond)
+		}
 		p.consume(";")
 		node.inc = p.simpleStmt()
 	}
 	node.then = p.block()
+	// init節で宣言した変数は繰り返しごとに別の変数になる。
+	// アドレスが取られている変数はヒープに割り当て、繰り返しの終わりに新しい領域へコピーする。
+	if node.init != nil && node.init.kind == ND_VARDECL {
+		for _, variable := range node.init.lvar {
+			if variable.addressed {
+				variable.heap = true
+				variable.escape = loopvar_escape
+				node.loopvar = append(node.loopvar, variable)
+			}
+		}
+	}
 	p.leave_scope() // forスコープを削除
 	return node
 }
 
-// SimpleStmt       = ExpressionStmt | Assignment .
+// 複合代入演算子と対応する二項演算
+var assign_op = map[string]NodeKind{
+	"+=":  ND_ADD,
+	"-=":  ND_SUB,
+	"*=":  ND_MUL,
+	"/=":  ND_DIV,
+	"%=":  ND_MOD,
+	"&=":  ND_AND,
+	"|=":  ND_OR,
+	"^=":  ND_XOR,
+	"<<=": ND_SHL,
+	">>=": ND_SHR,
+	"&^=": ND_ANDNOT,
+}
+
+// SimpleStmt       = ExpressionStmt | IncDecStmt | Assignment | ShortVarDecl .
 // ExpressionStmt   = expr .
-// Assignment       = expr "=" expr .
+// IncDecStmt       = expr ( "++" | "--" ) .
+// Assignment       = ExpressionList "=" ExpressionList | expr assign_op expr .
+// assign_op        = [ "+" | "-" | "*" | "/" | "%" | "&" | "|" | "^" | "<<" | ">>" | "&^" ] "=" .
+// ShortVarDecl     = IdentifierList ":=" ExpressionList .
 func (p *Parser) simpleStmt() *Node {
-	lhs := p.exprOrNil()
+	if p.isShortVarDecl() {
+		return p.shortVarDecl()
+	}
+	lhs := p.assignLhs()
 	switch {
 	case lhs == nil:
 		return &Node{kind: ND_EMPTY_STMT}
-	case p.startsWithValue("="):
-		p.consume("=") // "="をスキップ
-		return &Node{kind: ND_ASSIGN_STMT, lhs: lhs, rhs: p.expr()}
+	case p.startsWithValue("=") || p.startsWithValue(","):
+		node := &Node{kind: ND_ASSIGN_STMT, lhslist: []*Node{lhs}}
+		for p.consumeIfPossible(",") != nil {
+			lhs := p.assignLhs()
+			if lhs == nil {
+				error_tok(p.code, p.peek(1)[0], "式が必要です")
+			}
+			node.lhslist = append(node.lhslist, lhs)
+		}
+		node.token = p.consume("=")
+		node.rhslist = p.exprList()
+		p.check_assign(node)
+		return node
+	case p.startsWithValue("++") || p.startsWithValue("--"):
+		token := p.read(1)[0] // "++"か"--"をスキップ
+		one := &Node{kind: ND_NUM, token: token, val: "1", ty: ty_untyped_int, num: big.NewInt(1)}
+		node := &Node{kind: ND_OPASSIGN_STMT, token: token, op: ND_ADD, lhs: lhs, rhs: one}
+		if token.val == "--" {
+			node.op = ND_SUB
+		}
+		p.check_assign(node)
+		return node
+	}
+	if op, ok := assign_op[p.peek(1)[0].val]; ok {
+		token := p.read(1)[0] // 複合代入演算子をスキップ
+		node := &Node{kind: ND_OPASSIGN_STMT, token: token, op: op, lhs: lhs, rhs: p.expr()}
+		p.check_assign(node)
+		return node
 	}
 	return &Node{kind: ND_EXPR_STMT, lhs: lhs}
 }
 
+// 代入文の左辺になりうる式
+// ブランク識別子は右辺の値を捨てるための変数になり、その型は右辺の値の型に決まる
+func (p *Parser) assignLhs() *Node {
+	if p.startsWithValue("_") && (p.peek(2)[1].val == "," || p.peek(2)[1].val == "=") {
+		return p.declareVar(p.read(1)[0], nil)
+	}
+	return p.exprOrNil()
+}
+
+// 次の文が短い変数宣言かどうか
+func (p *Parser) isShortVarDecl() bool {
+	i := p.i
+	for p.tokens[i].kind == TK_IDENT {
+		switch p.tokens[i+1].val {
+		case ":=":
+			return true
+		case ",":
+			i += 2
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// 短い変数宣言は同じスコープで宣言済みの変数を再宣言でき、その変数には代入する。
+// 少なくとも一つはブランクでない新しい変数を宣言しなければならない。
+func (p *Parser) shortVarDecl() *Node {
+	varnames := p.identList()
+	node := &Node{kind: ND_VARDECL, token: p.consume(":=")}
+	node.rhslist = p.exprList() // 右辺は変数を宣言する前に解析する
+	types := value_types(node.rhslist)
+	if len(types) != len(varnames) {
+		error_tok(p.code, node.token, "代入の個数が一致しません(%d個の変数に%d個の値)", len(varnames), len(types))
+	}
+
+	hasNew := false
+	for i, varname := range varnames {
+		for _, prev := range varnames[:i] {
+			if varname.val != "_" && prev.val == varname.val {
+				error_tok(p.code, varname, "変数%sが左辺で重複しています", varname.val)
+			}
+		}
+		if variable, ok := p.scope[0][varname.val]; ok {
+			// 宣言済みの変数に代入する
+			lhs := &Node{kind: ND_VAR, token: varname, val: varname.val, variable: variable, ty: variable.ty}
+			node.lhslist = append(node.lhslist, lhs)
+			continue
+		}
+		if varname.val != "_" {
+			hasNew = true
+		}
+		lhs := p.declareVar(varname, default_type(types[i]))
+		node.lhslist = append(node.lhslist, lhs)
+		node.lvar = append(node.lvar, lhs.variable)
+	}
+	if !hasNew {
+		error_tok(p.code, node.token, ":=の左辺に新しい変数がありません")
+	}
+	p.check_assign(node)
+	return node
+}
+
 func (p *Parser) expr() *Node {
 	expr := p.exprOrNil()
 	if expr == nil {
 		error_tok(p.code, p.peek(1)[0], "不正なトークンです")
 	}
+	p.check_value(expr)
 	return expr
 }
 
-// expr = add { "==" add | "!=" add | "<" add | "<=" add | ">" add | ">=" add } .
+// expr             = logand { "||" logand } .
 func (p *Parser) exprOrNil() *Node {
+	node := p.logand()
+	for p.startsWithValue("||") {
+		token := p.consume("||")
+		node = &Node{kind: ND_LOGOR, token: token, lhs: node, rhs: p.logand()}
+	}
+	p.add_type(node)
+	return node
+}
+
+// logand           = relational { "&&" relational } .
+func (p *Parser) logand() *Node {
+	node := p.relational()
+	for p.startsWithValue("&&") {
+		token := p.consume("&&")
+		node = &Node{kind: ND_LOGAND, token: token, lhs: node, rhs: p.relational()}
+	}
+	return node
+}
+
+// relational       = add { rel_op add } .
+// rel_op           = "==" | "!=" | "<" | "<=" | ">" | ">=" .
+func (p *Parser) relational() *Node {
 	node := p.add()
 	for {
 		switch {
 		case p.startsWithValue("=="):
-			p.consume("==")
-			node = &Node{kind: ND_EQ, lhs: node, rhs: p.add()}
+			token := p.consume("==")
+			node = &Node{kind: ND_EQ, token: token, lhs: node, rhs: p.add()}
 			continue
 		case p.startsWithValue("!="):
-			p.consume("!=")
-			node = &Node{kind: ND_NE, lhs: node, rhs: p.add()}
+			token := p.consume("!=")
+			node = &Node{kind: ND_NE, token: token, lhs: node, rhs: p.add()}
 			continue
 		case p.startsWithValue("<"):
-			p.consume("<")
-			node = &Node{kind: ND_LT, lhs: node, rhs: p.add()}
+			token := p.consume("<")
+			node = &Node{kind: ND_LT, token: token, lhs: node, rhs: p.add()}
 			continue
 		case p.startsWithValue("<="):
-			p.consume("<=")
-			node = &Node{kind: ND_LE, lhs: node, rhs: p.add()}
+			token := p.consume("<=")
+			node = &Node{kind: ND_LE, token: token, lhs: node, rhs: p.add()}
 			continue
 		case p.startsWithValue(">"):
-			p.consume(">")
-			node = &Node{kind: ND_LT, lhs: p.add(), rhs: node}
+			token := p.consume(">")
+			node = &Node{kind: ND_LT, token: token, lhs: p.add(), rhs: node}
 			continue
 		case p.startsWithValue(">="):
-			p.consume(">=")
-			node = &Node{kind: ND_LE, lhs: p.add(), rhs: node}
+			token := p.consume(">=")
+			node = &Node{kind: ND_LE, token: token, lhs: p.add(), rhs: node}
 			continue
 		}
 		return node
 	}
 }
 
-// add = mul { "+" mul | "-" mul } .
+// 加算の優先順位の演算子と対応する二項演算
+var add_op = map[string]NodeKind{
+	"+": ND_ADD,
+	"-": ND_SUB,
+	"|": ND_OR,
+	"^": ND_XOR,
+}
+
+// 乗算の優先順位の演算子と対応する二項演算
+var mul_op = map[string]NodeKind{
+	"*":  ND_MUL,
+	"/":  ND_DIV,
+	"%":  ND_MOD,
+	"<<": ND_SHL,
+	">>": ND_SHR,
+	"&":  ND_AND,
+	"&^": ND_ANDNOT,
+}
+
+// add              = mul { add_op mul } .
+// add_op           = "+" | "-" | "|" | "^" .
 func (p *Parser) add() *Node {
 	node := p.mul()
 	for {
-		switch {
-		case p.startsWithValue("+"):
-			p.consume("+")
-			node = &Node{kind: ND_ADD, lhs: node, rhs: p.mul()}
-			continue
-		case p.startsWithValue("-"):
-			p.consume("-")
-			node = &Node{kind: ND_SUB, lhs: node, rhs: p.mul()}
-			continue
+		kind, ok := add_op[p.peek(1)[0].val]
+		if !ok {
+			return node
 		}
-		return node
+		token := p.read(1)[0]
+		node = &Node{kind: kind, token: token, lhs: node, rhs: p.mul()}
 	}
 }
 
-// mul = unary { "*" unary | "/" unary } .
+// mul              = unary { mul_op unary } .
+// mul_op           = "*" | "/" | "%" | "<<" | ">>" | "&" | "&^" .
 func (p *Parser) mul() *Node {
 	node := p.unary()
 	for {
-		switch {
-		case p.startsWithValue("*"):
-			p.consume("*")
-			node = &Node{kind: ND_MUL, lhs: node, rhs: p.unary()}
-			continue
-		case p.startsWithValue("/"):
-			p.consume("/")
-			node = &Node{kind: ND_DIV, lhs: node, rhs: p.unary()}
-			continue
+		kind, ok := mul_op[p.peek(1)[0].val]
+		if !ok {
+			return node
 		}
-		return node
+		token := p.read(1)[0]
+		node = &Node{kind: kind, token: token, lhs: node, rhs: p.unary()}
 	}
 }
 
 // unary            = primary | unary_op unary .
-// unary_op         = "+" | "-" | "*" | "&" .
+// unary_op         = "+" | "-" | "^" | "*" | "&" .
 func (p *Parser) unary() *Node {
 	switch {
 	case p.startsWithValue("+"):
 		p.consume("+")
 		return p.unary()
 	case p.startsWithValue("-"):
-		p.consume("-")
-		zero := &Node{kind: ND_NUM, val: "0"}
-		return &Node{kind: ND_SUB, lhs: zero, rhs: p.unary()}
+		token := p.consume("-")
+		zero := &Node{kind: ND_NUM, token: token, val: "0", num: big.NewInt(0)}
+		return &Node{kind: ND_SUB, token: token, lhs: zero, rhs: p.unary()}
+	case p.startsWithValue("^"):
+		token := p.consume("^")
+		return &Node{kind: ND_BITNOT, token: token, lhs: p.unary()}
 	case p.startsWithValue("*"):
-		p.consume("*")
-		return &Node{kind: ND_DEREF, lhs: p.unary()}
+		token := p.consume("*")
+		return &Node{kind: ND_DEREF, token: token, lhs: p.unary()}
 	case p.startsWithValue("&"):
-		p.consume("&")
-		return &Node{kind: ND_ADDR, lhs: p.unary()}
+		token := p.consume("&")
+		node := &Node{kind: ND_ADDR, token: token, lhs: p.unary()}
+		mark_addressed(node.lhs)
+		return node
 	}
 	return p.primary()
 }
 
-// primary       = num | ident | funccall | "(" expr ")" .
+// primary       = operand { Selector | "[" expr "]" | Arguments } .
+// 関数型の値を呼び出す場合は関数値が指すクロージャを通じて呼び出す
 func (p *Parser) primary() *Node {
+	node := p.operand()
+	for node != nil {
+		switch {
+		case p.startsWithValue("."):
+			p.consume(".")
+			node = p.selector(node, p.consumeWithTokenKind(TK_IDENT))
+		case p.startsWithValue("["):
+			token := p.consume("[")
+			node = &Node{kind: ND_INDEX, token: token, lhs: node, rhs: p.expr()}
+			p.consume("]")
+		case p.startsWithValue("(") && node.kind == ND_FUNCNAME:
+			// 宣言された関数は直接呼び出す
+			fn := node.callee
+			node = &Node{kind: ND_FUNCCALL, token: node.token, val: fn.val, callee: fn}
+			p.arguments(node, fn.ty)
+		case p.startsWithValue("("):
+			p.add_type(node)
+			p.check_value(node)
+			if node.ty.kind != TY_FUNC {
+				error_tok(p.code, p.peek(1)[0], "%s型の値は呼び出せません", node.ty.name)
+			}
+			node = &Node{kind: ND_FUNCCALL, token: node.token, val: node.token.val, lhs: node}
+			p.arguments(node, node.lhs.ty)
+		default:
+			return node
+		}
+	}
+	return node
+}
+
+// operand       = num | ident | FunctionName | builtinCall | conversion | FunctionLit | "(" expr ")" .
+func (p *Parser) operand() *Node {
 	switch {
 	case p.startsWithTokenKind(TK_NUM):
 		return p.num()
 	case p.startsWithTokenKind(TK_IDENT):
-		if p.peek(2)[1].val == "(" {
-			return p.funccall()
+		if p.isTypeName() && p.peek(2)[1].val == "(" {
+			return p.conversion()
+		}
+		if p.isTypeName() && p.peek(2)[1].val == "." {
+			error_tok(p.code, p.peek(2)[1], "メソッド式には対応していません")
+		}
+		if _, ok := builtins[p.peek(1)[0].val]; ok && p.peek(2)[1].val == "(" {
+			return p.builtinCall()
+		}
+		if p.isFunctionName() {
+			return p.functionName()
 		}
 		return p.ident()
+	case p.startsWithValue("func"):
+		return p.funcLit()
 	case p.startsWithValue("("):
 		p.consume("(")
 		node := p.expr()
@@ -436,39 +1515,487 @@ func (p *Parser) primary() *Node {
 // num = digit { digit } .
 func (p *Parser) num() *Node {
 	token := p.consumeWithTokenKind(TK_NUM)
-	return &Node{kind: ND_NUM, token: token, val: token.val}
+	num, ok := new(big.Int).SetString(token.val, 0)
+	if !ok {
+		error_tok(p.code, token, "不正な整数リテラルです")
+	}
+	return &Node{kind: ND_NUM, token: token, val: token.val, num: num}
+}
+
+// 次のトークンが変数で隠されていない型名かどうか
+func (p *Parser) isTypeName() bool {
+	name := p.peek(1)[0].val
+	if p.lookup(name) != nil {
+		return false
+	}
+	_, ok := predeclared_types[name]
+	return ok || p.types[name] != nil
+}
+
+// conversion = Type "(" expr [ "," ] ")" .
+// 整数型どうし、真理値型どうし、基底型が同一の型どうしで変換でき、代入できる型にも変換できる
+func (p *Parser) conversion() *Node {
+	ty := p.typ()
+	token := p.consume("(")
+	node := &Node{kind: ND_CONV, token: token, lhs: p.expr(), ty: ty}
+	p.consumeIfPossible(",")
+	p.consume(")")
+	from := node.lhs.ty
+	if !(is_integer(from) && is_integer(ty)) && !(is_bool(from) && ty.kind == TY_BOOL) &&
+		!identical(underlying(from), underlying(ty)) && !assignable(from, ty) {
+		error_tok(p.code, token, "%s型を%s型に変換できません", from.name, ty.name)
+	}
+	if ty.kind == TY_INTERFACE {
+		p.check_const(node.lhs, ty) // 具象型の値はインターフェースの値に変換する
+		return node
+	}
+	if node.lhs.kind == ND_NUM {
+		// 定数の変換は変換先の型の定数になり、値はその型で表せなければならない
+		p.check_const(node.lhs, ty)
+		return &Node{kind: ND_NUM, token: node.lhs.token, ty: ty, num: node.lhs.num, val: node.lhs.val}
+	}
+	p.check_const(node.lhs, ty) // 定数でない回数でシフトした型なし定数は変換先の型で計算する
+	return node
+}
+
+// Type          = TypeName | "*" Type | "[" "]" Type | "func" Signature | StructType | InterfaceType .
+// ポインタ、スライス、関数の型の中では、定義を解析中の型も参照できる
+func (p *Parser) typ() *Type {
+	if p.startsWithValue("func") {
+		p.consume("func")
+		p.indirect++
+		ty, _, _ := p.signatureType()
+		p.indirect--
+		return ty
+	}
+	if p.startsWithValue("*") {
+		p.consume("*")
+		p.indirect++
+		ty := pointer_to(p.typ())
+		p.indirect--
+		return ty
+	}
+	if p.startsWithValue("[") {
+		token := p.consume("[")
+		if !p.startsWithValue("]") {
+			error_tok(p.code, token, "配列型には対応していません")
+		}
+		p.consume("]")
+		p.indirect++
+		ty := slice_of(p.typ())
+		p.indirect--
+		return ty
+	}
+	if p.startsWithValue("struct") {
+		return p.structType()
+	}
+	if p.startsWithValue("interface") {
+		return p.interfaceType()
+	}
+	return p.typeName(p.consumeWithTokenKind(TK_IDENT))
+}
+
+// StructType    = "struct" "{" { FieldDecl ";" } "}" .
+// FieldDecl     = IdentifierList Type | EmbeddedField .
+// EmbeddedField = [ "*" ] TypeName .
+// フィールドの大きさを決めるので、フィールドの型は定義を解析中の型を直接含んではならない
+func (p *Parser) structType() *Type {
+	p.consume("struct")
+	p.consume("{")
+	indirect := p.indirect
+	p.indirect = 0
+	fields := []*Field{}
+	p.semicolonList("}", func() {
+		decl := []*Field{}
+		if p.startsWithValue("*") || p.startsWithTokenKind(TK_IDENT) && (p.peek(2)[1].val == ";" || p.peek(2)[1].val == "}") {
+			// 埋め込みフィールドの名前は型名になる
+			star := p.consumeIfPossible("*")
+			token := p.consumeWithTokenKind(TK_IDENT)
+			var ty *Type
+			if star != nil {
+				p.indirect++
+				ty = p.typeName(token)
+				p.indirect--
+				if ty.kind == TY_PTR || ty.kind == TY_INTERFACE {
+					error_tok(p.code, token, "*%s型は埋め込みフィールドにできません", token.val)
+				}
+				ty = pointer_to(ty)
+			} else {
+				ty = p.typeName(token)
+				if ty.kind == TY_PTR {
+					error_tok(p.code, token, "ポインタ型の%sは埋め込みフィールドにできません", token.val)
+				}
+			}
+			decl = append(decl, &Field{name: token.val, ty: ty, embedded: true, token: token})
+		} else {
+			names := p.identList()
+			ty := p.typ()
+			for _, name := range names {
+				decl = append(decl, &Field{name: name.val, ty: ty, token: name})
+			}
+		}
+		for _, f := range decl {
+			for _, g := range fields {
+				if g.name == f.name && f.name != "_" {
+					error_tok(p.code, f.token, "フィールド%sが重複しています", f.name)
+				}
+			}
+			fields = append(fields, f)
+		}
+	})
+	p.indirect = indirect
+	return struct_type(fields)
+}
+
+// InterfaceType = "interface" "{" { ( MethodSpec | TypeName ) ";" } "}" .
+// MethodSpec    = ident Signature .
+// 埋め込んだインターフェース型のメソッドも加える
+func (p *Parser) interfaceType() *Type {
+	p.consume("interface")
+	p.consume("{")
+	methods := []*Field{}
+	add := func(m *Field, embedded bool) {
+		for _, n := range methods {
+			if n.name == m.name {
+				if embedded && identical(n.ty, m.ty) {
+					return // 埋め込んだインターフェースどうしで同じメソッドは一つにまとめる
+				}
+				error_tok(p.code, m.token, "メソッド%sが重複しています", m.name)
+			}
+		}
+		methods = append(methods, m)
+	}
+	p.semicolonList("}", func() {
+		token := p.consumeWithTokenKind(TK_IDENT)
+		if !p.startsWithValue("(") {
+			indirect := p.indirect
+			p.indirect = 0 // 埋め込んだインターフェースのメソッドを加えるには定義が必要
+			ty := p.typeName(token)
+			p.indirect = indirect
+			if ty.kind != TY_INTERFACE {
+				error_tok(p.code, token, "%sはインターフェース型ではありません", token.val)
+			}
+			for _, m := range ty.fields {
+				add(&Field{name: m.name, ty: m.ty, token: token}, true)
+			}
+			return
+		}
+		p.indirect++
+		ty, _, _ := p.signatureType()
+		p.indirect--
+		add(&Field{name: token.val, ty: ty, token: token}, false)
+	})
+	return interface_type(methods)
+}
+
+// 型名tokenが表す型を返す
+// パッケージで宣言した型は、ポインタ、スライス、関数の型の中でなければ先に定義を解析する
+func (p *Parser) typeName(token *Token) *Type {
+	if decl := p.types[token.val]; decl != nil && p.lookup(token.val) == nil {
+		if p.indirect == 0 {
+			p.resolveType(decl, token)
+		}
+		return decl.ty
+	}
+	ty, ok := predeclared_types[token.val]
+	if !ok {
+		error_tok(p.code, token, "%sは型ではありません", token.val)
+	}
+	return ty
 }
 
 // ident = letter { alnum } .
 func (p *Parser) ident() *Node {
 	token := p.consumeWithTokenKind(TK_IDENT)
-	// スコープから変数を探す
-	for _, m := range p.scope {
-		if variable, ok := m[token.val]; ok {
-			return &Node{kind: ND_VAR, token: token, val: token.val, variable: variable}
+	if variable := p.lookup(token.val); variable != nil {
+		if variable.ty == nil {
+			p.resolveGlobal(p.globalDecl(variable), token) // 後で宣言されたパッケージレベルの変数や定数
 		}
+		if c := variable.constant; c != nil {
+			return &Node{kind: ND_NUM, token: token, val: c.val, ty: c.ty, num: c.num} // 定数はその値に置き換える
+		}
+		if variable.fn != nil && variable.fn != p.fn {
+			variable = p.capture(variable, p.fn) // 外側の関数のローカル変数
+		}
+		return &Node{kind: ND_VAR, token: token, val: token.val, variable: variable}
+	}
+	if token.val == "true" || token.val == "false" {
+		return &Node{kind: ND_NUM, token: token, val: token.val, ty: ty_untyped_bool, num: bool_value(token.val == "true")}
+	}
+	if token.val == "nil" {
+		return &Node{kind: ND_ZERO, token: token, val: token.val, ty: ty_untyped_nil} // 使う場所の型のゼロ値になる
+	}
+	if token.val == "iota" {
+		if p.iota < 0 {
+			error_tok(p.code, token, "iotaは定数宣言の中でしか使えません")
+		}
+		return &Node{kind: ND_NUM, token: token, val: token.val, ty: ty_untyped_int, num: big.NewInt(int64(p.iota))}
 	}
 	// 変数がいずれのスコープにも宣言されていないならエラー
 	error_tok(p.code, token, "変数が宣言されていません。")
 	return nil
 }
 
-// funccall = ident "(" [ ExpressionList [ "," ] ] ")" .
-// ExpressionList = Expression { "," Expression } .
-func (p *Parser) funccall() *Node {
-	funcname := p.consumeWithTokenKind(TK_IDENT)
-	node := &Node{kind: ND_FUNCCALL, val: funcname.val, args: []*Node{}}
-	p.consume("(")
-	for !p.startsWithValue(")") {
-		node.args = append(node.args, p.expr())
-		if !p.startsWithValue(",") && !p.startsWithValue(")") {
-			error_tok(p.code, p.peek(1)[0], "不正なトークン")
+// パッケージレベルの変数や定数variableを宣言しているGlobalDecl
+func (p *Parser) globalDecl(variable *Var) *GlobalDecl {
+	for _, decl := range p.globals {
+		for _, v := range decl.vars {
+			if v == variable {
+				return decl
+			}
+		}
+	}
+	return nil
+}
+
+// 内側のスコープから順に変数を探す
+func (p *Parser) lookup(name string) *Var {
+	for _, m := range p.scope {
+		if variable, ok := m[name]; ok {
+			return variable
 		}
+	}
+	return nil
+}
+
+// 組み込み関数
+var builtins = map[string]NodeKind{
+	"len":     ND_LEN,
+	"cap":     ND_CAP,
+	"print":   ND_PRINT,
+	"println": ND_PRINT,
+	"new":     ND_NEW,
+}
+
+// builtinCall = ( "len" | "cap" ) "(" expr [ "," ] ")" | ( "print" | "println" ) "(" [ ExpressionList [ "," ] ] ")" | "new" "(" Type [ "," ] ")" .
+func (p *Parser) builtinCall() *Node {
+	token := p.consumeWithTokenKind(TK_IDENT)
+	node := &Node{kind: builtins[token.val], token: token, val: token.val}
+	p.consume("(")
+	if node.kind == ND_NEW {
+		// newは型Tのゼロ値の領域をヒープに割り当て、そのポインタを返す
+		node.ty = pointer_to(p.typ())
 		p.consumeIfPossible(",")
+		p.consume(")")
+		return node
 	}
-	if len(node.args) > 6 {
-		error_tok(p.code, funcname, "引数が多すぎます(6個以内)")
+	if node.kind == ND_PRINT {
+		// print と println は任意個の引数を取り、値を返さない
+		node.args = []*Node{}
+		if !p.startsWithValue(")") {
+			node.args = p.exprList()
+			p.consumeIfPossible(",")
+		}
+		p.consume(")")
+		return node
 	}
+	node.lhs = p.expr()
+	p.consumeIfPossible(",")
 	p.consume(")")
 	return node
 }
+
+// 次の識別子が宣言された関数の名前かどうか
+// 同じ名前の変数があれば関数は隠される 宣言されていない名前の呼び出しも関数の呼び出しとしてエラーにする
+func (p *Parser) isFunctionName() bool {
+	name := p.peek(1)[0].val
+	if p.isQualified() {
+		return true
+	}
+	if p.lookup(name) != nil {
+		return false
+	}
+	_, ok := p.funcs[name]
+	return ok || name == "init" || p.peek(2)[1].val == "(" && name != "nil"
+}
+
+// FunctionName = ident | QualifiedIdent .
+// 呼び出さずに値として使う関数は、関数のアドレスだけを入れた静的なクロージャを指す関数値になる
+func (p *Parser) functionName() *Node {
+	funcname := p.consumeWithTokenKind(TK_IDENT)
+	fn := p.callee(funcname)
+	node := &Node{kind: ND_FUNCNAME, token: funcname, val: fn.val, ty: fn.ty, callee: fn}
+	if !p.startsWithValue("(") && fn.ty.cvariadic {
+		error_tok(p.code, funcname, "Cの可変長引数の関数%sは値として使えません", fn.val)
+	}
+	return node
+}
+
+// Arguments = "(" [ ExpressionList [ "..." ] [ "," ] ] ")" .
+// 型ftyの関数を呼び出す引数を解析する
+func (p *Parser) arguments(node *Node, fty *Type) {
+	node.ty = result_type(fty)
+	node.args = []*Node{}
+	p.consume("(")
+	var dots *Token
+	if !p.startsWithValue(")") {
+		node.args = p.exprList() // f(g())のように複数の値を返す関数呼び出しを引数にできる
+		dots = p.consumeIfPossible("...")
+		p.consumeIfPossible(",")
+	}
+	p.consume(")")
+	switch {
+	case dots != nil && !fty.variadic:
+		error_tok(p.code, dots, "可変長引数の関数ではないので...を使えません")
+	case dots == nil && fty.variadic:
+		node.args = p.variadicArgs(node, fty)
+	}
+	p.check_call(node, fty)
+}
+
+// FunctionLit = "func" Signature Block .
+// 関数リテラルは名前のない関数として解析し、外側の関数のローカル変数を参照すればその変数を捕捉する
+func (p *Parser) funcLit() *Node {
+	token := p.consume("func")
+	fn := &Node{kind: ND_FUNCDECL, token: token, outer: p.fn}
+	n := 1 // 外側の関数の中で何番目の関数リテラルか
+	for _, lit := range p.funclit {
+		if lit.outer == p.fn {
+			n++
+		}
+	}
+	p.funclit = append(p.funclit, fn)
+	if p.fn == nil {
+		fn.val = "glob..func" + strconv.Itoa(n) // パッケージレベルの変数の初期化子の中
+	} else {
+		fn.val = p.fn.val + ".func" + strconv.Itoa(n)
+	}
+	outer, lvar, pending := p.fn, p.lvar, p.pending
+	p.fn, p.lvar, p.pending = fn, []*Var{}, nil
+	p.enter_scope()
+	ty, names, resultNames := p.signatureType()
+	fn.ty = ty
+	fn.params = p.paramVars(names, ty.params)
+	fn.results = p.paramVars(resultNames, ty.results)
+	fn.body = p.block()
+	fn.lvar = p.lvar
+	p.leave_scope()
+	p.fn, p.lvar, p.pending = outer, lvar, pending
+	return &Node{kind: ND_FUNCLIT, token: token, val: fn.val, ty: ty, callee: fn}
+}
+
+// 関数fnの中から外側の関数の変数variableを参照するための変数
+// 関数リテラルは捕捉した変数のヒープ領域へのポインタをクロージャに入れて受け取る
+// 外側の関数も関数リテラルなら、その関数でも同じ変数を捕捉する
+func (p *Parser) capture(variable *Var, fn *Node) *Var {
+	if variable.fn == fn {
+		return variable
+	}
+	outer := p.capture(variable, fn.outer)
+	for _, c := range fn.captures {
+		if c.capture == outer {
+			return c
+		}
+	}
+	if outer == variable {
+		// 捕捉した変数は関数リテラルと共有するのでヒープに割り当てる
+		variable.addressed = true
+		variable.heap = true
+		if variable.escape == "" {
+			variable.escape = closure_escape
+		}
+	}
+	c := &Var{name: variable.name, ty: variable.ty, token: variable.token, heap: true, fn: fn, capture: outer}
+	fn.captures = append(fn.captures, c)
+	return c
+}
+
+// 次の識別子がインポートしたパッケージの名前で修飾されているかどうか
+// 同じ名前の変数があればパッケージ名は隠される
+func (p *Parser) isQualified() bool {
+	name := p.peek(1)[0].val
+	return p.peek(2)[1].val == "." && p.imported(name) != nil && p.lookup(name) == nil
+}
+
+// QualifiedIdent = PackageName "." ident .
+// 呼び出す関数を探す パッケージ名で修飾した名前はインポートしたパッケージの関数
+func (p *Parser) callee(funcname *Token) *Node {
+	if p.startsWithValue(".") {
+		pkg := p.imported(funcname.val)
+		p.consume(".")
+		name := p.consumeWithTokenKind(TK_IDENT)
+		fn, ok := pkg.funcs[name.val]
+		if !ok {
+			error_tok(p.code, name, "%s.%sが宣言されていません", pkg.name, name.val)
+		}
+		pkg.used = true
+		return fn
+	}
+	if funcname.val == "init" {
+		error_tok(p.code, funcname, "関数initは呼び出せません")
+	}
+	fn, ok := p.funcs[funcname.val]
+	if !ok {
+		error_tok(p.code, funcname, "関数%sが宣言されていません", funcname.val)
+	}
+	return fn
+}
+
+// 関数呼び出しの引数を型ftyの関数の仮引数と照合する
+func (p *Parser) check_call(node *Node, fty *Type) {
+	types := value_types(node.args)
+	params := fty.params
+	if fty.cvariadic && len(types) > len(params) {
+		// Cの可変長引数の部分には1ワードの値をその型のまま渡す
+		params = append([]*Type{}, params...)
+		for _, ty := range types[len(params):] {
+			params = append(params, default_type(ty))
+		}
+	}
+	if len(types) != len(params) {
+		error_tok(p.code, node.token, "引数の個数が一致しません(%d個の仮引数に%d個の値)", len(params), len(types))
+	}
+	for i, ty := range types {
+		arg := node.args[0] // 関数呼び出しの結果を渡す場合はその呼び出しの位置
+		if len(node.args) == len(types) {
+			arg = node.args[i]
+		}
+		if !assignable(ty, params[i]) {
+			error_tok(p.code, arg.token, "%s型の値を%s型の引数として渡せません", ty.name, params[i].name)
+		}
+		if i >= len(fty.params) && words(ty) != 1 {
+			error_tok(p.code, arg.token, "%s型の値はCの可変長引数に渡せません", ty.name)
+		}
+		if len(node.args) == len(types) {
+			p.check_const(node.args[i], params[i])
+		} else {
+			p.check_tuple_value(arg.token, ty, params[i])
+		}
+	}
+}
+
+// 可変長引数に渡す引数を暗黙に作ったスライスにまとめる
+// 引数がなければ可変長引数はnilになる
+func (p *Parser) variadicArgs(node *Node, fty *Type) []*Node {
+	n := len(fty.params) - 1
+	if len(node.args) < n {
+		return node.args // 引数の個数の誤りはcheck_callで報告する
+	}
+	ty := fty.params[n]
+	if len(node.args) == 1 && node.args[0].ty.kind == TY_TUPLE {
+		// f(g())ではgの結果のうちn個目より後ろのものを可変長引数にする
+		call := node.args[0]
+		results := call.ty.results
+		for _, result := range results[n:] {
+			if !assignable(result, ty.base) {
+				error_tok(p.code, call.token, "%s型の値を%s型の引数として渡せません", result.name, ty.base.name)
+			}
+			p.check_tuple_value(call.token, result, ty.base)
+		}
+		types := append(results[:n:n], ty)
+		tuple := &Type{kind: TY_TUPLE, name: type_list_name(types), results: types}
+		return []*Node{{kind: ND_PACK, token: call.token, ty: tuple, lhs: call}}
+	}
+	if len(node.args) == n {
+		return append(node.args, &Node{kind: ND_ZERO, token: node.token, ty: ty})
+	}
+	slice := &Node{kind: ND_SLICELIT, token: node.args[n].token, ty: ty}
+	for _, arg := range node.args[n:] {
+		if !assignable(arg.ty, ty.base) {
+			error_tok(p.code, arg.token, "%s型の値を%s型の引数として渡せません", arg.ty.name, ty.base.name)
+		}
+		p.check_const(arg, ty.base)
+		slice.args = append(slice.args, arg)
+	}
+	return append(node.args[:n:n], slice)
+}
@@ -0,0 +1,258 @@
+package main
+
+// runtimeパッケージ
+func runtime_package() map[string]*Node {
+	return map[string]*Node{
+		"GC": package_func("runtime", "GC", func_type(nil, nil, false)),
+	}
+}
+
+// 生成したコードから呼び出すランタイムルーチン
+// レジスタの使い方は引数をrdi, rsi, ...で受け取り、raxで返す。
+// 呼び出し元がスタックに積んでいる値以外は保存しないので、どこからでも呼び出せる。
+const runtime_asm = `
+# runtime.panicdivide(pos, len) ゼロ除算のパニック posはパニックした位置を表す文字列
+runtime.panicdivide:
+  lea   rdx, [rip+runtime.msg.divide]
+  mov   ecx, OFFSET runtime.msg.divide.end - runtime.msg.divide
+  jmp   runtime.panicpos
+
+# runtime.panicshift(pos, len) シフト回数が負の場合のパニック
+runtime.panicshift:
+  lea   rdx, [rip+runtime.msg.shift]
+  mov   ecx, OFFSET runtime.msg.shift.end - runtime.msg.shift
+  jmp   runtime.panicpos
+
+# runtime.panicnil(pos, len) nilの関数値を呼び出した場合のパニック
+runtime.panicnil:
+  lea   rdx, [rip+runtime.msg.nil]
+  mov   ecx, OFFSET runtime.msg.nil.end - runtime.msg.nil
+  jmp   runtime.panicpos
+
+# runtime.panicindex(pos, len, index, length) インデックスが範囲外の場合のパニック
+runtime.panicindex:
+  push  rdi
+  push  rsi
+  push  rdx
+  push  rcx
+  lea   rdi, [rip+runtime.msg.index]
+  mov   esi, OFFSET runtime.msg.index.end - runtime.msg.index
+  call  runtime.writeerr
+  mov   rdi, [rsp+8]
+  call  runtime.writeint
+  cmp   QWORD PTR [rsp+8], 0
+  jl    runtime.panicindex.negative
+  lea   rdi, [rip+runtime.msg.length]
+  mov   esi, OFFSET runtime.msg.length.end - runtime.msg.length
+  call  runtime.writeerr
+  mov   rdi, [rsp]
+  call  runtime.writeint
+  jmp   runtime.panicindex.pos
+runtime.panicindex.negative:
+  # 負のインデックスには長さを表示せず"]"だけを書き込む
+  lea   rdi, [rip+runtime.msg.length]
+  mov   esi, 1
+  call  runtime.writeerr
+runtime.panicindex.pos:
+  add   rsp, 16
+  pop   rsi
+  pop   rdi
+  jmp   runtime.panictrace
+
+# runtime.panicpos(pos, poslen, msg, msglen) パニックのメッセージと位置を標準エラー出力に書き込み、終了ステータス2で終了する
+runtime.panicpos:
+  push  rdi
+  push  rsi
+  mov   rdi, rdx
+  mov   rsi, rcx
+  call  runtime.writeerr
+  pop   rsi
+  pop   rdi
+
+# runtime.panictrace(pos, poslen) ゴルーチンの情報とパニックした位置を標準エラー出力に書き込み、終了ステータス2で終了する
+runtime.panictrace:
+  push  rdi
+  push  rsi
+  lea   rdi, [rip+runtime.msg.goroutine]
+  mov   esi, OFFSET runtime.msg.goroutine.end - runtime.msg.goroutine
+  call  runtime.writeerr
+  pop   rsi
+  pop   rdi
+  call  runtime.writeerr
+  lea   rdi, [rip+runtime.msg.newline]
+  mov   esi, 1
+  jmp   runtime.fatal
+
+# runtime.fatal(msg, len) メッセージを標準エラー出力に書き込み、終了ステータス2で終了する
+runtime.fatal:
+  call  runtime.writeerr
+  mov   edi, 2
+
+# runtime.exit(code) exit_groupシステムコールで終了ステータスcodeで終了する
+runtime.exit:
+  mov   eax, 231
+  syscall
+
+# runtime.printint(n) 組み込み関数printで符号付き整数を出力する
+runtime.printint:
+  jmp   runtime.writeint
+
+# runtime.printuint(n) 組み込み関数printで符号なし整数を出力する
+runtime.printuint:
+  jmp   runtime.writeuint
+
+# runtime.printbool(b) 組み込み関数printで真理値をtrueかfalseで出力する
+runtime.printbool:
+  lea   rax, [rip+runtime.msg.true]
+  mov   esi, OFFSET runtime.msg.true.end - runtime.msg.true
+  lea   rdx, [rip+runtime.msg.false]
+  mov   ecx, OFFSET runtime.msg.false.end - runtime.msg.false
+  test  dil, dil
+  cmove rax, rdx
+  cmove esi, ecx
+  mov   rdi, rax
+  jmp   runtime.writeerr
+
+# runtime.printpointer(p) 組み込み関数printでポインタを0xから始まる16進数で出力する
+runtime.printpointer:
+  jmp   runtime.writehex
+
+# runtime.printslice(ptr, len, cap) 組み込み関数printでスライスを[len/cap]0xptrの形で出力する
+runtime.printslice:
+  push  rdi
+  push  rdx
+  push  rsi
+  mov   edi, '['
+  call  runtime.writebyte
+  pop   rdi
+  call  runtime.writeint
+  mov   edi, '/'
+  call  runtime.writebyte
+  pop   rdi
+  call  runtime.writeint
+  mov   edi, ']'
+  call  runtime.writebyte
+  pop   rdi
+  jmp   runtime.writehex
+
+# runtime.printsp() 組み込み関数printlnで引数の間の空白を出力する
+runtime.printsp:
+  mov   edi, ' '
+  jmp   runtime.writebyte
+
+# runtime.printnl() 組み込み関数printlnで最後の改行を出力する
+runtime.printnl:
+  mov   edi, '\n'
+  jmp   runtime.writebyte
+
+# runtime.writeint(n) 符号付き整数nを10進数で標準エラー出力に書き込む
+runtime.writeint:
+  test  rdi, rdi
+  jns   runtime.writeuint
+  push  rdi
+  mov   edi, '-'
+  call  runtime.writebyte
+  pop   rdi
+  neg   rdi # 最小値は符号反転しても負のままだが、符号なしとして書き込めば正しい
+
+# runtime.writeuint(n) 符号なし整数nを10進数で標準エラー出力に書き込む
+runtime.writeuint:
+  sub   rsp, 24
+  lea   rsi, [rsp+24]
+  mov   rax, rdi
+  mov   ecx, 10
+runtime.writeuint.loop:
+  xor   edx, edx
+  div   rcx
+  add   dl, '0'
+  dec   rsi
+  mov   [rsi], dl
+  test  rax, rax
+  jne   runtime.writeuint.loop
+  mov   rdi, rsi
+  lea   rsi, [rsp+24]
+  sub   rsi, rdi
+  call  runtime.writeerr
+  add   rsp, 24
+  ret
+
+# runtime.writehex(n) 符号なし整数nを0xから始まる16進数で標準エラー出力に書き込む
+runtime.writehex:
+  sub   rsp, 24
+  lea   rsi, [rsp+24]
+  mov   rax, rdi
+  lea   rcx, [rip+runtime.hexdigits]
+runtime.writehex.loop:
+  mov   edx, eax
+  and   edx, 15
+  mov   dl, [rcx+rdx]
+  dec   rsi
+  mov   [rsi], dl
+  shr   rax, 4
+  jne   runtime.writehex.loop
+  sub   rsi, 2
+  mov   WORD PTR [rsi], 0x7830 # "0x"
+  mov   rdi, rsi
+  lea   rsi, [rsp+24]
+  sub   rsi, rdi
+  call  runtime.writeerr
+  add   rsp, 24
+  ret
+
+# runtime.writebyte(c) 1バイトの文字cを標準エラー出力に書き込む
+runtime.writebyte:
+  push  rdi
+  mov   rdi, rsp
+  mov   esi, 1
+  call  runtime.writeerr
+  pop   rdi
+  ret
+
+# runtime.writeerr(buf, len) 標準エラー出力に書き込む
+runtime.writeerr:
+  mov   rdx, rsi
+  mov   rsi, rdi
+  mov   edi, 2
+  mov   eax, 1
+  syscall
+  ret
+
+.section .rodata
+runtime.msg.divide:
+  .ascii "panic: runtime error: integer divide by zero"
+runtime.msg.divide.end:
+runtime.msg.shift:
+  .ascii "panic: runtime error: negative shift amount"
+runtime.msg.shift.end:
+runtime.msg.nil:
+  .ascii "panic: runtime error: invalid memory address or nil pointer dereference"
+runtime.msg.nil.end:
+runtime.msg.index:
+  .ascii "panic: runtime error: index out of range ["
+runtime.msg.index.end:
+runtime.msg.length:
+  .ascii "] with length "
+runtime.msg.length.end:
+runtime.msg.goroutine:
+  .ascii "\n\ngoroutine 1 [running]:\n"
+runtime.msg.goroutine.end:
+runtime.msg.newline:
+  .ascii "\n"
+runtime.msg.true:
+  .ascii "true"
+runtime.msg.true.end:
+runtime.msg.false:
+  .ascii "false"
+runtime.msg.false.end:
+runtime.hexdigits:
+  .ascii "0123456789abcdef"
+.bss
+  .align 8
+runtime.argc:
+  .zero 8
+runtime.argv:
+  .zero 8
+runtime.envp:
+  .zero 8
+.text
+`
@@ -1,126 +1,861 @@
 #! /bin/bash
 cat <<EOF | gcc -xc -c -o tmp2.o -
-int ret3() { return 3; }
-int ret5() { return 5; }
-int add(int x, int y) { return x+y; }
+#include <stdarg.h>
+#include <stdio.h>
+#include <unistd.h>
+
+long ret3() { return 3; }
+long ret5() { return 5; }
+long add(long x, long y) { return x+y; }
 int sub(int x, int y) { return x-y; }
 
-int add6(int a, int b, int c, int d, int e, int f) {
+long add6(long a, long b, long c, long d, long e, long f) {
   return a+b+c+d+e+f;
 }
+
+long add8(long a, long b, long c, long d, long e, long f, long g, long h) {
+  return a+b+c+d+e+f+g+h;
+}
+
+// call命令を実行したときにrspが16の倍数ならフレームのアドレスも16の倍数になる
+long aligned() { return ((long)__builtin_frame_address(0) & 15) == 0; }
+
+// 可変長引数の関数は呼び出し元がalをセットしていないとベクトルレジスタを退避しようとする
+long sumv(long n, ...) {
+  va_list ap;
+  va_start(ap, n);
+  long sum = 0;
+  for (int i = 0; i < n; i++)
+    sum += va_arg(ap, long);
+  va_end(ap);
+  return sum;
+}
+
+// Goの可変長引数はスライスとしてポインタ、長さ、容量の3ワードで渡される
+long sumslice(long *p, long len, long cap) {
+  long sum = 0;
+  for (long i = 0; i < len; i++)
+    sum += p[i];
+  return sum;
+}
+
+// 標準出力に整数を書き出す
+long printint(long n) {
+  char buf[32];
+  int len = snprintf(buf, sizeof(buf), "%ld\n", n);
+  return write(1, buf, len);
+}
+
+long deref(long *p) { return *p; }
+
+// 標準出力のバッファに整数を書き込む バッファはexitで書き出される
+long bufint(long n) { return printf("%ld\n", n); }
+
+long weigh10(long a, long b, long c, long d, long e, long f, long g, long h, long i, long j) {
+  return a*1+b*2+c*3+d*4+e*5+f*6+g*7+h*8+i*9+j*10;
+}
 EOF
 
+# Cで定義した関数の宣言 Cのlongはintに、intはint32に対応する
+extern='import "os"
+func ret3() int
+func ret5() int
+func add(x, y int) int
+func sub(x, y int32) int32
+func add6(a, b, c, d, e, f int) int
+func add8(a, b, c, d, e, f, g, h int) int
+func aligned() int
+func sumv(n int, ...) int
+func sumslice(xs ...int) int
+func weigh10(a, b, c, d, e, f, g, h, i, j int) int
+func printint(n int) int
+func deref(p *int) int
+func bufint(n int) int
+'
+
+# 終了ステータスと標準出力を検査する 標準出力は省略すると空
 assert() {
   expected="$1"
   input="$2"
+  output="$3"
 
   ./gocmps "$input" > tmp.s || exit
   cc -o tmp tmp.s tmp2.o
-  ./tmp
+  stdout="$(./tmp)"
   actual="$?"
 
-  if [ "$actual" = "$expected" ]; then
+  if [ "$actual" = "$expected" ] && [ "$stdout" = "$output" ]; then
+    echo "$input => $actual"
+  else
+    echo "$input => $expected $output expected, but got $actual $stdout"
+    printf '\033[31m%s\033[m\n' 'NG'
+    exit 1
+  fi
+}
+
+# 組み込み関数printとprintlnが標準エラー出力に書き込んだ内容を検査する
+assert_print() {
+  expected="$1"
+  input="$2"
+
+  ./gocmps "$input" > tmp.s || exit
+  cc -o tmp tmp.s tmp2.o
+  actual="$(./tmp 2>&1 > /dev/null)"
+  status="$?"
+
+  if [ "$status" = 0 ] && [ "$actual" = "$expected" ]; then
+    echo "$input => $actual"
+  else
+    echo "$input => $expected expected, but got $status: $actual"
+    printf '\033[31m%s\033[m\n' 'NG'
+    exit 1
+  fi
+}
+
+# -mフラグでエスケープ解析が標準エラー出力に書き込んだ内容を検査する
+assert_escape() {
+  expected="$1"
+  input="$2"
+
+  actual="$(./gocmps -m "$input" 2>&1 > tmp.s)"
+  status="$?"
+
+  if [ "$status" = 0 ] && [ "$actual" = "$expected" ]; then
     echo "$input => $actual"
   else
-    echo "$input => $expected expected, but got $actual"
+    echo "$input => $expected expected, but got $status: $actual"
     printf '\033[31m%s\033[m\n' 'NG'
     exit 1
   fi
 }
 
-assert 0  'func main() { return 0 }'
-assert 42 'func main() { return 42 }'
-assert 21 'func main() { return 5+20-4 }'
-assert 41 'func main() { return 12 + 34 - 5 }'
-assert 47 'func main() { return 5+6*7 }'
-assert 15 'func main() { return 5*(9-6) }'
-assert 4  'func main() { return (3+5)/2 }'
-assert 10 'func main() { return -10+20 }'
-assert 10 'func main() { return - -10 }'
-assert 10 'func main() { return - - +10 }'
-assert 25 'func main() { return - 5 * - 5 }'
-
-assert 0  'func main() { return 0==1 }'
-assert 1  'func main() { return 42==42 }'
-assert 1  'func main() { return 0!=1 }'
-assert 0  'func main() { return 42!=42 }'
-assert 1  'func main() { return 0<1 }'
-assert 0  'func main() { return 1<1 }'
-assert 0  'func main() { return 2<1 }'
-assert 1  'func main() { return 0<=1 }'
-assert 1  'func main() { return 1<=1 }'
-assert 0  'func main() { return 2<=1 }'
-assert 1  'func main() { return 1>0 }'
-assert 0  'func main() { return 1>1 }'
-assert 0  'func main() { return 1>2 }'
-assert 1  'func main() { return 1>=0 }'
-assert 1  'func main() { return 1>=1 }'
-assert 0  'func main() { return 1>=2 }'
-
-assert 1  'func main() { return 1; 2; 3 }'
-assert 2  'func main() { 1; return 2; 3 }'
-assert 3  'func main() { 1; 2; return 3 }'
-
-assert 3  'func main() { var a=3; return a }'
-assert 8  'func main() { var a=3; var z=5; return a+z }'
-assert 3  'func main() { var foo=3; return foo }'
-assert 8  'func main() { var foo123=3; var bar=5; return foo123+bar }'
-
-assert 3  'func main() { { 1; { 2; }; return 3; }; }'
-assert 4  'func main() { {}; {;}; {1;}; {2;3}; return 4}'
-
-assert 6  'func main() { var a int = 1; var b int; b=2; var c=3; return a+b+c}'
-assert 4  'func main() { var a int; {a=4}; return a}'
-assert 0  'func main() { var a int; {var a int = 4}; return a}'
-
-assert 3  'func main() { if 0 { return 2 }; return 3 }'
-assert 3  'func main() { if 1-1 { return 2 }; return 3 }'
-assert 2  'func main() { if 1 { return 2 }; return 3 }'
-assert 2  'func main() { if 2-1 { return 2 }; return 3 }'
-assert 4  'func main() { if 0 { 1; 2; return 3 } else { return 4 } }'
-assert 3  'func main() { if 1 { 1; 2; return 3 } else { return 4 } }'
-assert 5  'func main() { if 0 { return 3 } else if 0 { return 4 } else { return 5 } }'
-assert 2  'func main() { if ;1 { return 2 }; return 3 }'
-assert 3  'func main() { if ;0 { return 2 }; return 3 }'
-assert 2  'func main() { var i int; if i=1;i { return 2 }; return 3 }'
-assert 3  'func main() { var i int; if i=0;i { return 2 }; return 3 }'
-
-assert 2  'func main() { var i int; if i=1;i { return 2 }; return 3 }'
-assert 3  'func main() { var i int; if i=0;i { return 2 }; return 3 }'
-
-assert 55 'func main() { var i=0; var j=0; for i=0; i<=10; i=i+1 { j=i+j }; return j; }'
-assert 3  'func main() { for { return 3 }; return 5 }'
-assert 3  'func main() { for 1 { return 3 }; return 5 }'
-assert 5  'func main() { for 0 { return 3 }; return 5 }'
-assert 3  'func main() { for ;; { return 3 }; return 5 }'
-assert 5  'func main() { for ;0; { return 3 }; return 5 }'
-assert 3  'func main() { var i int; for ;;i=i+1 { return 3 }; return 5 }'
-
-assert 3  'func main() { return ret3() }'
-assert 1  'func main() { if ret5() == 5 {return 1}; return 0 }'
-assert 8  'func main() { return add(3, 5) }'
-assert 2  'func main() { return sub(5, 3) }'
-assert 21 'func main() { return add6(1,2,3,4,5,6) }'
-
-assert 32 'func main() { return ret32() }; func ret32() int { return 32 }'
-assert 5  'func main() { return myadd(2,3) }; func myadd(a int, b int) int { return a+b }'
+# libcを使わずに_startから始めるプログラムをldだけでリンクして、終了ステータスと標準出力を検査する
+assert_freestanding() {
+  expected="$1"
+  input="$2"
+  output="$3"
+
+  ./gocmps -runtime=freestanding "$input" > tmp.s || exit
+  as -o tmp.o tmp.s && ld -o tmp tmp.o || exit
+  stdout="$(./tmp)"
+  actual="$?"
+
+  if [ "$actual" = "$expected" ] && [ "$stdout" = "$output" ]; then
+    echo "$input => $actual"
+  else
+    echo "$input => $expected $output expected, but got $actual $stdout"
+    printf '\033[31m%s\033[m\n' 'NG'
+    exit 1
+  fi
+}
+
+assert_error() {
+  position="$1"
+  input="$2"
+
+  ./gocmps "$input" > tmp.s 2> tmp.err
+  status="$?"
+  actual="$(tail -n 1 tmp.err | sed 's/^ *^ //')"
+
+  if [ "$status" = 1 ] && [ "${actual%%]*}]" = "$position" ]; then
+    echo "$input => $actual"
+  else
+    echo "$input => error at $position expected, but got $status: $(cat tmp.err)"
+    printf '\033[31m%s\033[m\n' 'NG'
+    exit 1
+  fi
+}
+
+assert_panic() {
+  expected="$1"
+  position="$2"
+  input="$3"
+
+  ./gocmps "$input" > tmp.s || exit
+  cc -o tmp tmp.s tmp2.o
+  ./tmp 2> tmp.err
+  status="$?"
+  message="$(head -n 1 tmp.err)"
+  actual="$(tail -n 1 tmp.err)"
+
+  if [ "$status" = 2 ] && [ "$message" = "$expected" ] && [ "$actual" = "	$position" ]; then
+    echo "$input => $message $position"
+  else
+    echo "$input => $expected $position expected, but got $status: $(cat tmp.err)"
+    printf '\033[31m%s\033[m\n' 'NG'
+    exit 1
+  fi
+}
+
+assert 0  'import "os"; func main() { os.Exit(0) }'
+assert 42 'import "os"; func main() { os.Exit(42) }'
+assert 21 'import "os"; func main() { os.Exit(5+20-4) }'
+assert 41 'import "os"; func main() { os.Exit(12 + 34 - 5) }'
+assert 47 'import "os"; func main() { os.Exit(5+6*7) }'
+assert 15 'import "os"; func main() { os.Exit(5*(9-6)) }'
+assert 4  'import "os"; func main() { os.Exit((3+5)/2) }'
+assert 10 'import "os"; func main() { os.Exit(-10+20) }'
+assert 10 'import "os"; func main() { os.Exit(- -10) }'
+assert 10 'import "os"; func main() { os.Exit(- - +10) }'
+assert 25 'import "os"; func main() { os.Exit(- 5 * - 5) }'
+assert 2  'import "os"; func main() { os.Exit(17%5) }'
+assert 2  'import "os"; func main() { os.Exit(6&3) }'
+assert 7  'import "os"; func main() { os.Exit(6|3) }'
+assert 5  'import "os"; func main() { os.Exit(6^3) }'
+assert 4  'import "os"; func main() { os.Exit(6&^3) }'
+assert 16 'import "os"; func main() { os.Exit(1<<4) }'
+assert 16 'import "os"; func main() { os.Exit(256>>4) }'
+assert 1  'import "os"; func main() { os.Exit(^5+7) }'
+assert 13 'import "os"; func main() { os.Exit(1+2*3<<1) }'
+assert 3  'import "os"; func main() { os.Exit(2|1&3) }'
+assert 3  'import "os"; func main() { os.Exit(5-3^1) }'
+assert_print 'true' 'func main() { println(1+1==2 && 3>2) }'
+assert_print 'true' 'func main() { println(0==1 || 2==2) }'
+assert_print 'false' 'func main() { println(0==1 || 2!=2) }'
+assert 0  'import "os"; func inc(p *int) int { *p+=1; return 1 }; func main() { var n=0; if 0==1 && inc(&n)==1 {}; if 1==1 || inc(&n)==1 {}; os.Exit(n) }'
+assert 2  'import "os"; func inc(p *int) int { *p+=1; return 1 }; func main() { var n=0; if 1==1 && inc(&n)==1 {}; if 0==1 || inc(&n)==1 {}; os.Exit(n) }'
+
+assert_print 'false' 'func main() { println(0==1) }'
+assert_print 'true' 'func main() { println(42==42) }'
+assert_print 'true' 'func main() { println(0!=1) }'
+assert_print 'false' 'func main() { println(42!=42) }'
+assert_print 'true' 'func main() { println(0<1) }'
+assert_print 'false' 'func main() { println(1<1) }'
+assert_print 'false' 'func main() { println(2<1) }'
+assert_print 'true' 'func main() { println(0<=1) }'
+assert_print 'true' 'func main() { println(1<=1) }'
+assert_print 'false' 'func main() { println(2<=1) }'
+assert_print 'true' 'func main() { println(1>0) }'
+assert_print 'false' 'func main() { println(1>1) }'
+assert_print 'false' 'func main() { println(1>2) }'
+assert_print 'true' 'func main() { println(1>=0) }'
+assert_print 'true' 'func main() { println(1>=1) }'
+assert_print 'false' 'func main() { println(1>=2) }'
+
+assert 1  'import "os"; func main() { os.Exit(1); 2; 3 }'
+assert 2  'import "os"; func main() { 1; os.Exit(2); 3 }'
+assert 3  'import "os"; func main() { 1; 2; os.Exit(3) }'
+
+assert 3  'import "os"; func main() { var a=3; os.Exit(a) }'
+assert 8  'import "os"; func main() { var a=3; var z=5; os.Exit(a+z) }'
+assert 3  'import "os"; func main() { var foo=3; os.Exit(foo) }'
+assert 8  'import "os"; func main() { var foo123=3; var bar=5; os.Exit(foo123+bar) }'
+
+assert 3  'import "os"; func main() { { 1; { 2; }; os.Exit(3); }; }'
+assert 4  'import "os"; func main() { {}; {;}; {1;}; {2;3}; os.Exit(4)}'
+
+assert 6  'import "os"; func main() { var a int = 1; var b int; b=2; var c=3; os.Exit(a+b+c)}'
+assert 4  'import "os"; func main() { var a int; {a=4}; os.Exit(a)}'
+assert 0  'import "os"; func main() { var a int; {var a int = 4}; os.Exit(a)}'
+
+assert 3  'import "os"; func main() { x := 3; os.Exit(x) }'
+assert 34 'import "os"; func main() { a, b := 3, 4; os.Exit(a*10+b) }'
+assert 23 'import "os"; func main() { a := 1; a, b := 2, 3; os.Exit(a*10+b) }'
+assert 12 'import "os"; func main() { a, b := 1, 2; b, c := a, b; os.Exit(b*10+c) }'
+assert 5  'import "os"; func main() { a := 5; { a, b := 1, 2; b = a }; os.Exit(a) }'
+assert 2  'import "os"; func main() { _, b := 1, 2; os.Exit(b) }'
+assert 3  'import "os"; func main() { var a, b int = 1, 2; os.Exit(a+b) }'
+assert 7  'import "os"; func main() { var a, b = 3, 4; os.Exit(a+b) }'
+assert 0  'import "os"; func main() { var a, b int; os.Exit(a+b) }'
+assert 5  'import "os"; func main() { var _ = 4; var _, c = 3, 5; os.Exit(c) }'
+assert_print 'true' 'func main() { var a, b int8 = 127, 1; a += b; println(a == -128) }'
+assert 3  'import "os"; func main() { var ( a = 1; b int = 2 ); os.Exit(a+b) }'
+assert 6  'import "os"
+func main() {
+  var (
+    a int = 1
+    b, c = 2, 3
+  )
+  os.Exit(a+b+c)
+}'
+assert 0  'import "os"; func main() { var (); os.Exit(0) }'
+assert_error '[1:52]' 'import "os"; func main() { a := 1; b, a := 2, 3; a := 4; os.Exit(a+b) }'
+assert_error '[1:41]' 'import "os"; func main() { a := 1; _, a := 2, 3; os.Exit(a) }'
+assert_error '[1:34]' 'import "os"; func main() { a, b, a := 1, 2, 3; os.Exit(a+b) }'
+assert_error '[1:38]' 'import "os"; func main() { var a, b, a int; os.Exit(a+b) }'
+assert_error '[1:43]' 'import "os"; func main() { var a int; var a = 2; os.Exit(a) }'
+assert_error '[1:33]' 'import "os"; func main() { a, b := 1; os.Exit(a+b) }'
+assert_error '[1:37]' 'import "os"; func main() { var a, b = 1, 2, 3; os.Exit(a+b) }'
+assert_error '[1:48]' 'import "os"; func main() { var ( a = 1; b int; a int ); os.Exit(a+b) }'
+
+assert 3  'import "os"; func main() { if false { os.Exit(2) }; os.Exit(3) }'
+assert 3  'import "os"; func main() { if 1-1 != 0 { os.Exit(2) }; os.Exit(3) }'
+assert 2  'import "os"; func main() { if true { os.Exit(2) }; os.Exit(3) }'
+assert 2  'import "os"; func main() { if 2-1 != 0 { os.Exit(2) }; os.Exit(3) }'
+assert 4  'import "os"; func main() { if false { 1; 2; os.Exit(3) } else { os.Exit(4) } }'
+assert 3  'import "os"; func main() { if true { 1; 2; os.Exit(3) } else { os.Exit(4) } }'
+assert 5  'import "os"; func main() { if false { os.Exit(3) } else if false { os.Exit(4) } else { os.Exit(5) } }'
+assert 2  'import "os"; func main() { if ;true { os.Exit(2) }; os.Exit(3) }'
+assert 3  'import "os"; func main() { if ;false { os.Exit(2) }; os.Exit(3) }'
+assert 2  'import "os"; func main() { var i int; if i=1;i != 0 { os.Exit(2) }; os.Exit(3) }'
+assert 3  'import "os"; func main() { var i int; if i=0;i != 0 { os.Exit(2) }; os.Exit(3) }'
+
+assert 2  'import "os"; func main() { var i int; if i=1;i != 0 { os.Exit(2) }; os.Exit(3) }'
+assert 3  'import "os"; func main() { var i int; if i=0;i != 0 { os.Exit(2) }; os.Exit(3) }'
+
+assert 55 'import "os"; func main() { var i=0; var j=0; for i=0; i<=10; i=i+1 { j=i+j }; os.Exit(j); }'
+assert 3  'import "os"; func main() { for { os.Exit(3) }; os.Exit(5) }'
+assert 3  'import "os"; func main() { for true { os.Exit(3) }; os.Exit(5) }'
+assert 5  'import "os"; func main() { for false { os.Exit(3) }; os.Exit(5) }'
+assert 3  'import "os"; func main() { for ;; { os.Exit(3) }; os.Exit(5) }'
+assert 5  'import "os"; func main() { for ;false; { os.Exit(3) }; os.Exit(5) }'
+assert 3  'import "os"; func main() { var i int; for ;;i=i+1 { os.Exit(3) }; os.Exit(5) }'
+assert 45 'import "os"; func main() { var j=0; for i:=0; i<10; i=i+1 { j=i+j }; os.Exit(j); }'
+assert 3  'import "os"; func main() { var i=3; for i:=0; i<10; i=i+1 {}; os.Exit(i); }'
+assert 1  'import "os"; func main() { var x=0; var p=&x; var q=&x; for i:=0; i<2; i=i+1 { if i==0 { p=&i }; if i==1 { q=&i } }; os.Exit(*p*10+*q) }'
+assert 35 'import "os"; func main() { var x=0; var p=&x; var q=&x; for i:=3; i<6; i=i+1 { if i==3 { p=&i }; if i==5 { q=&i } }; os.Exit(*p*10+*q) }'
+assert 21 'import "os"; func main() { var x=0; var p=&x; for i:=0; i<3; i=i+1 { p=&i; *p=*p*2 }; os.Exit(*p*10+x+1) }'
+
+assert 4  'import "os"; func main() { var i=3; i++; os.Exit(i) }'
+assert 2  'import "os"; func main() { var i=3; i--; os.Exit(i) }'
+assert 10 'import "os"; func main() { var i=3; i+=7; os.Exit(i) }'
+assert 4  'import "os"; func main() { var i=7; i-=3; os.Exit(i) }'
+assert 21 'import "os"; func main() { var i=7; i*=3; os.Exit(i) }'
+assert 3  'import "os"; func main() { var i=7; i/=2; os.Exit(i) }'
+assert 1  'import "os"; func main() { var i=7; i%=3; os.Exit(i) }'
+assert 2  'import "os"; func main() { var i=6; i&=3; os.Exit(i) }'
+assert 7  'import "os"; func main() { var i=6; i|=3; os.Exit(i) }'
+assert 5  'import "os"; func main() { var i=6; i^=3; os.Exit(i) }'
+assert 4  'import "os"; func main() { var i=6; i&^=3; os.Exit(i) }'
+assert 24 'import "os"; func main() { var i=3; i<<=3; os.Exit(i) }'
+assert 3  'import "os"; func main() { var i=24; i>>=3; os.Exit(i) }'
+assert 0  'import "os"; func main() { var x=1; var n=64; os.Exit(int(x<<n)) }'
+assert 0  'import "os"; func main() { var x=1; var n=70; x<<=n; os.Exit(x) }'
+assert 255 'import "os"; func main() { var x=-8; var n=100; os.Exit(x>>n) }'
+assert 255 'import "os"; func main() { var x int8=-128; os.Exit(int(x>>10)) }'
+assert 240 'import "os"; func main() { var x int8=-128; os.Exit(int(x>>3)) }'
+assert 0  'import "os"; func main() { var x uint8=128; os.Exit(int(x>>10)) }'
+assert 25 'import "os"; func main() { var x uint8=200; os.Exit(int(x>>3)) }'
+assert 1  'import "os"; func main() { var x uint64=^uint64(0); os.Exit(int(x>>63)) }'
+assert 254 'import "os"; func main() { var x uint8=255; x<<=1; os.Exit(int(x)) }'
+assert 0  'import "os"; func main() { var x uint16=1; var n uint8=16; os.Exit(int(x<<n)) }'
+assert_panic 'panic: runtime error: negative shift amount' '[1:47]' 'import "os"; func main() { var n=-1; os.Exit(1<<n) }'
+assert_print 'true' 'func main() { var x int8=127; x+=1; println(x == -128) }'
+assert_print 'true' 'func main() { var x uint8=255; x++; println(x == 0) }'
+assert_print 'true' 'func main() { var x int16=32767; println(x+1 < 0) }'
+assert_print 'true' 'func main() { var x int32=-2147483648; println(x-1 > 0) }'
+assert_print 'true' 'func main() { var a uint64=0; var b uint64=1; println(^a > b) }'
+assert_print 'true' 'func main() { var a uint=^uint(0); println(a/2 == 9223372036854775807) }'
+assert_print 'true' 'func main() { var a uint=^uint(0); println(a%10 == 5) }'
+assert 44 'import "os"; func main() { var x int=300; os.Exit(int(int8(x))) }'
+assert_print 'true' 'func main() { var x int8=-1; println(uint8(x) == 255) }'
+assert 6  'import "os"; func main() { var x byte=6; var y=&x; os.Exit(int(*y)) }'
+
+assert_print 'true' 'func main() { var a=-7; var b=2; println(a/b == -3 && a%b == -1) }'
+assert_print 'true' 'func main() { var a=7; var b=-2; println(a/b == -3 && a%b == 1) }'
+assert_print 'true' 'func main() { var a int64=-9223372036854775807-1; var b int64=-1; println(a/b == a) }'
+assert_print 'true' 'func main() { var a int64=-9223372036854775807-1; var b int64=-1; println(a%b == 0) }'
+assert_print 'true' 'func main() { var a int64=-9223372036854775807-1; a/=-1; println(a == -9223372036854775807-1) }'
+assert_print 'true' 'func main() { var a int32=-2147483648; var b int32=-1; println(a/b == a && a%b == 0) }'
+assert_print 'true' 'func main() { var a int16=-32768; var b int16=-1; println(a/b == a && a%b == 0) }'
+assert_print 'true' 'func main() { var a int8=-128; var b int8=-1; println(a/b == a && a%b == 0) }'
+assert_print 'true' 'func main() { var a int=-9223372036854775807-1; var b=-1; println(a/b == a && a%b == 0) }'
+assert_print 'true' 'func main() { var a uint8=255; var b uint8=2; println(a/b == 127 && a%b == 1) }'
+assert_panic 'panic: runtime error: integer divide by zero' '[1:47]' 'import "os"; func main() { var a=0; os.Exit(10/a) }'
+assert_panic 'panic: runtime error: integer divide by zero' '[1:47]' 'import "os"; func main() { var a=0; os.Exit(10%a) }'
+assert_panic 'panic: runtime error: integer divide by zero' '[1:52]' 'import "os"; func main() { var a=10; var b uint8; a/=int(b); os.Exit(a) }'
+assert_panic 'panic: runtime error: integer divide by zero' '[3:12]' 'import "os"
+func div(a int8, b int8) int8 {
+  return a / b
+}
+func main() { os.Exit(int(div(1, 0))) }'
+assert 8  'import "os"; func main() { var i=3; var p=&i; *p+=5; os.Exit(i) }'
+assert 81 'import "os"; func twice(c *int, p *int) *int { *c+=1; return p }; func main() { var n=0; var x=5; *twice(&n, &x)+=3; os.Exit(x*10+n) }'
+assert 45 'import "os"; func main() { var j=0; for i:=0; i<10; i++ { j+=i }; os.Exit(j) }'
+
+assert 3  "$extern"'func main() { os.Exit(ret3()) }'
+assert 1  "$extern"'func main() { if ret5() == 5 {os.Exit(1)}; os.Exit(0) }'
+assert 8  "$extern"'func main() { os.Exit(add(3, 5)) }'
+assert 2  "$extern"'func main() { os.Exit(int(sub(5, 3))) }'
+assert 21 "$extern"'func main() { os.Exit(add6(1,2,3,4,5,6)) }'
+
+assert 32 'import "os"; func main() { os.Exit(ret32()) }; func ret32() int { return 32 }'
+assert 5  'import "os"; func main() { os.Exit(myadd(2,3)) }; func myadd(a int, b int) int { return a+b }'
+assert 123 'import "os"; func f(a, b int, c int8) int { return a*100+b*10+int(c) }; func main() { os.Exit(f(1, 2, 3)) }'
+assert 7  'import "os"; func f(int, *int) int { return 7 }; func main() { var x int; os.Exit(f(1, &x)) }'
+assert 2  'import "os"; func f(_ int, b int) int { return b }; func main() { os.Exit(f(1, 2)) }'
+assert 3  'import "os"; func f() (int) { return 3 }; func main() { os.Exit(f()) }'
+assert 56 'import "os"; func set(p, q *int, v int) { *p = v; *q = v+1 }; func main() { var a, b int; set(&a, &b, 5); os.Exit(a*10+b) }'
+assert 4  'import "os"; func set(p *int, v int) { if v > 5 { return }; *p = v }; func main() { var a int; set(&a, 4); set(&a, 6); os.Exit(a) }'
+assert 1  'import "os"; func nop() {}; func main() { nop(); os.Exit(1) }'
+assert_error '[1:25]' 'import "os"; func f() { return 1 }; func main() { os.Exit(0) }'
+assert_error '[1:29]' 'import "os"; func f() int { return }; func main() { os.Exit(0) }'
+assert_error '[1:48]' 'import "os"; func f() int8 { var a int; return a }; func main() { os.Exit(0) }'
+assert_error '[1:49]' 'import "os"; func f() {}; func main() { os.Exit(f()) }'
+assert_error '[1:49]' 'import "os"; func f() {}; func main() { var a = f(); os.Exit(a) }'
+assert_error '[1:44]' 'import "os"; func f() {}; func main() { if f() == 1 { os.Exit(1) }; os.Exit(0) }'
+assert_error '[1:28]' 'import "os"; func f(a int, *int) {}; func main() { os.Exit(0) }'
+assert_error '[1:28]' 'import "os"; func f(a int, b) {}; func main() { os.Exit(0) }'
+assert_error '[1:24]' 'import "os"; func f(a, a int) {}; func main() { os.Exit(0) }'
+assert 7 'import "os"; func f() (int, int) { return 3, 4 }; func main() { a, b := f(); os.Exit(a+b) }'
+assert 34 'import "os"; func f() (int, int) { return 3, 4 }; func main() { var a, b = f(); os.Exit(a*10+b) }'
+assert 43 'import "os"; func f() (int, int) { return 3, 4 }; func main() { var a, b int; b, a = f(); os.Exit(a*10+b) }'
+assert 4 'import "os"; func f() (int, int) { return 3, 4 }; func main() { _, b := f(); os.Exit(b) }'
+assert 3 'import "os"; func f() (int, int) { return 3, 4 }; func main() { var a int; a, _ = f(); os.Exit(a) }'
+assert 1 'import "os"; func f() (int, int) { return 3, 4 }; func main() { f(); os.Exit(1) }'
+assert 1 'import "os"; func f() (int, int) { return 3, 4 }; func diff(a, b int) int { return b-a }; func main() { os.Exit(diff(f())) }'
+assert 43 'import "os"; func f() (int, int) { return 3, 4 }; func g() (int, int) { a, b := f(); return b, a }; func main() { a, b := g(); os.Exit(a*10+b) }'
+assert 34 'import "os"; func f() (int, int) { return 3, 4 }; func g() (int, int) { return f() }; func main() { a, b := g(); os.Exit(a*10+b) }'
+assert 123 'import "os"; func f() (int8, *int, uint) { x := 2; return -1, &x, 3 }; func main() { a, p, c := f(); os.Exit(int(a)*-100 + *p*10 + int(c)) }'
+assert 45 'import "os"
+func f() (int, int, int, int, int, int, int, int, int) { return 1, 2, 3, 4, 5, 6, 7, 8, 9 }
+func main() { a, b, c, d, e, f, g, h, i := f(); os.Exit(a+b+c+d+e+f+g+h+i) }'
+assert 66 'import "os"
+func f() (int, int, int, int, int, int, int, int, int, int, int) { return 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11 }
+func g() (int, int, int, int, int, int, int, int, int, int, int) { return f() }
+func main() { a, b, c, d, e, f, g, h, i, j, k := g(); os.Exit(a+b+c+d+e+f+g+h+i+j+k) }'
+assert 119 'import "os"
+func f(x int) (int, int, int, int, int, int, int, int, int, int, int8) { return x, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11 }
+func main() { _, _, _, _, _, _, _, _, _, j, k := f(1); a, _, _, _, _, _, _, _, _, _, _ := f(98); os.Exit(a+j+int(k)) }'
+assert 21 'import "os"; func main() { a, b := 1, 2; a, b = b, a; os.Exit(a*10+b) }'
+assert 231 'import "os"; func main() { a, b, c := 1, 2, 3; a, b, c = b, c, a; os.Exit(a*100+b*10+c) }'
+assert 12 'import "os"; func main() { x := 1; p := &x; x, *p = 2, 12; os.Exit(x) }'
+assert 3 'import "os"; func main() { i := 1; var a, b int; i, a = 3, i; b = i; os.Exit(b*a) }'
+assert_error '[1:75]' 'import "os"; func f() (int, int) { return 3, 4 }; func main() { var a int = f(); os.Exit(a) }'
+assert_error '[1:73]' 'import "os"; func f() (int, int) { return 3, 4 }; func main() { a, b, c := f(); os.Exit(a) }'
+assert_error '[1:93]' 'import "os"; func f() (int, int) { return 3, 4 }; func main() { var a int8; var b int; a, b = f(); os.Exit(b) }'
+assert_error '[1:65]' 'import "os"; func f() (int, int) { return 3, 4 }; func main() { f() + 1; os.Exit(0) }'
+assert_error '[1:66]' 'import "os"; func f() (int, int) { return 3, 4 }; func g() int { return f() }; func main() { os.Exit(g()) }'
+assert_error '[1:79]' 'import "os"; func f() (int, int) { return 3, 4 }; func main() { var a int; a, 1 = f(); os.Exit(a) }'
+assert_error '[1:47]' 'import "os"; func main() { a, b := 1, 2; a, b = 1; os.Exit(a+b) }'
+assert 0 'import "os"; func f() (n int) { return }; func main() { os.Exit(f()) }'
+assert 7 'import "os"; func f() (n int) { n = 7; return }; func main() { os.Exit(f()) }'
+assert 34 'import "os"; func f() (a, b int) { a, b = 3, 4; return }; func main() { a, b := f(); os.Exit(a*10+b) }'
+assert 5 'import "os"; func f() (n int) { n = 7; return 5 }; func main() { os.Exit(f()) }'
+assert 3 'import "os"; func f(x int) (_ int, n int) { n = x; return }; func main() { a, b := f(3); os.Exit(a+b) }'
+assert 111 'import "os"; func inc(p *int) { *p = *p + 1 }; func f() (a, b, c, d, e, f, g, h, i, j int) { defer inc(&j); j = 10; a = 1; return }
+func main() { a, _, _, _, _, _, _, _, _, j := f(); os.Exit(a*100+j) }'
+assert 3 'import "os"; func f() (n int) { for i := 0; i < 3; i++ { n++ }; return }; func main() { os.Exit(f()) }'
+assert 12 'import "os"; func f() (n int) { n = 3; { n := 5; n++ }; n *= 4; return }; func main() { os.Exit(f()) }'
+assert 6 'import "os"; func double(p *int) { *p = *p * 2 }; func f() (n int) { defer double(&n); n = 3; return }; func main() { os.Exit(f()) }'
+assert 10 'import "os"; func double(p *int) { *p = *p * 2 }; func f() (n int) { defer double(&n); return 5 }; func main() { os.Exit(f()) }'
+assert 23 'import "os"; func set(p *int, v int) { *p = *p*10 + v }; func f() (n int) { defer set(&n, 3); defer set(&n, 2); return 0 }; func main() { os.Exit(f()) }'
+assert 1 'import "os"; func set(p *int, v int) { *p = v }; func f() (n int) { x := 1; defer set(&n, x); x = 3; n = x; return }; func main() { os.Exit(f()) }'
+assert 123 'import "os"; func set(p *int, v int) { *p = *p*10 + v }; func f() (n int) { for i := 3; i > 0; i-- { defer set(&n, i) }; return }; func main() { os.Exit(f()) }'
+assert 9 'import "os"; func get(p *int, q *int) { *q = *p }; func f() (n int, m int) { defer get(&n, &m); return 9, 1 }; func main() { _, m := f(); os.Exit(m) }'
+assert 8 'import "os"; func add3(p *int) { *p += 3 }; func f() (int, int) { return 2, 3 }; func g() (n, m int) { defer add3(&n); return f() }; func main() { a, b := g(); os.Exit(a+b) }'
+assert 1 "$extern"'func f() (n int) { defer ret3(); return 1 }; func main() { os.Exit(f()) }'
+assert_error '[1:54]' 'import "os"; func f() (n int) { { n := 2; if n > 0 { return } }; return }; func main() { os.Exit(f()) }'
+assert_error '[1:37]' 'import "os"; func f() int { n := 1; return }; func main() { os.Exit(f()) }'
+assert_error '[1:29]' 'import "os"; func f(n int) (n int) { return }; func main() { os.Exit(f(1)) }'
+assert_error '[1:28]' 'import "os"; func main() { defer 1; os.Exit(0) }'
+assert 0 'import "os"; func f(xs ...int) int { return len(xs) }; func main() { os.Exit(f()) }'
+assert 3 'import "os"; func f(xs ...int) int { return len(xs) }; func main() { os.Exit(f(1, 2, 3)) }'
+assert 3 'import "os"; func f(xs ...int) int { return cap(xs) }; func main() { os.Exit(f(1, 2, 3)) }'
+assert 6 'import "os"; func sum(xs ...int) int { s := 0; for i := 0; i < len(xs); i++ { s += xs[i] }; return s }; func main() { os.Exit(sum(1, 2, 3)) }'
+assert 106 'import "os"; func f(prefix int, xs ...int) int { s := prefix; for i := 0; i < len(xs); i++ { s += xs[i] }; return s }; func main() { os.Exit(f(100, 1, 2, 3)) }'
+assert 100 'import "os"; func f(prefix int, xs ...int) int { s := prefix; for i := 0; i < len(xs); i++ { s += xs[i] }; return s }; func main() { os.Exit(f(100)) }'
+assert 6 'import "os"; func mk(xs ...int) []int { return xs }; func sum(xs ...int) int { s := 0; for i := 0; i < len(xs); i++ { s += xs[i] }; return s }; func main() { s := mk(1, 2, 3); os.Exit(sum(s...)) }'
+assert 16 'import "os"; func mk(xs ...int) []int { return xs }; func f(prefix int, xs ...int) int { return prefix + len(xs) * xs[2] }; func main() { os.Exit(f(1, mk(4, 5, 5)...)) }'
+assert 7 'import "os"; func mk(xs ...int) []int { return xs }; func main() { s := mk(1, 2, 3); s[1] = 7; os.Exit(s[1]) }'
+assert 9 'import "os"; func mk(xs ...int) []int { return xs }; func main() { s := mk(1, 2, 3); t := s; t[0] = 9; os.Exit(s[0]) }'
+assert 14 'import "os"; func g() (int, int, int) { return 1, 2, 3 }; func f(a int, xs ...int) int { return a*10 + len(xs)*xs[0] + xs[1] - 3 }; func main() { os.Exit(f(g())) }'
+assert 6 'import "os"; func g() (int, int, int) { return 1, 2, 3 }; func sum(xs ...int) int { s := 0; for i := 0; i < len(xs); i++ { s += xs[i] }; return s }; func main() { os.Exit(sum(g())) }'
+assert 1 'import "os"; func g() (int, []int) { var e []int; return 7, e }; func f(a int, xs ...[]int) int { if len(xs) == 1 && len(xs[0]) == 0 { return 1 }; return 0 }; func main() { os.Exit(f(g())) }'
+assert 1 'import "os"; func g() int { return 7 }; func f(a int, xs ...int) int { if len(xs) == 0 { return 1 }; return 0 }; func main() { os.Exit(f(g())) }'
+assert 9 'import "os"; func g() (int, int) { return 4, 5 }; func f(a int, xs ...int) int { return a + xs[0] }; func main() { os.Exit(f(g())) }'
+assert 123 'import "os"; func mk(xs ...int) []int { return xs }; func f() ([]int, []int, []int, int, []int) { var c []int; return mk(1), mk(2, 3), c, 4, mk(5, 6, 7) }
+func main() { a, b, c, d, e := f(); os.Exit(len(a)*100 + len(b)*10 + len(e) + len(c) + d - 4) }'
+assert 5 'import "os"; func mk(xs ...int) []int { return xs }; func main() { s := mk(1, 2, 3); p := &s[2]; *p = 5; os.Exit(s[2]) }'
+assert 11 'import "os"; func mk(xs ...int) []int { return xs }; func main() { s := mk(1, 2, 3); s[0] += 10; s[1]++; os.Exit(s[0]) }'
+assert 0 'import "os"; func main() { var s []int; os.Exit(len(s)) }'
+assert 2 'import "os"; func set(p *int, xs ...int) { *p = len(xs) }; func f() (n int) { defer set(&n, 1, 2); return }; func main() { os.Exit(f()) }'
+assert 45 'import "os"; func mk(xs ...int8) []int8 { return xs }; func main() { s := mk(-1, 127, 2); s[1]++; os.Exit(int(s[0]) + int(s[1]) + int(s[2]) + 172) }'
+assert 255 'import "os"; func mk(xs ...uint8) []uint8 { return xs }; func main() { s := mk(1, 2); s[0] -= 2; os.Exit(int(s[0])) }'
+assert 33 'import "os"; func mk(xs ...int) []int { return xs }; func mk2(xs ...[]int) [][]int { return xs }; func main() { s := mk2(mk(1, 2), mk(3, 4, 5)); os.Exit(len(s[1])*10 + s[1][len(s[1])-1] - 2) }'
+assert 25 'import "os"; func mk(xs ...int) []int { return xs }; func f(a []int, b []int) ([]int, []int) { return b, a }; func main() { s, t := f(mk(1, 2, 3), mk(4, 5)); os.Exit(len(s)*10 + t[2] + s[1] - 3) }'
+assert 3 'import "os"; func mk(xs ...int) []int { return xs }; func f() (s []int) { s = mk(1, 2, 3); return }; func main() { os.Exit(len(f())) }'
+assert 2 'import "os"; func mk(xs ...int) []int { return xs }; func main() { a, b := mk(1), mk(2, 3); a, b = b, a; os.Exit(len(a)) }'
+assert 20 'import "os"; func mk(xs ...int) []int { return xs }; func main() { var s []int; for i := 0; i < 5; i++ { s = mk(i, len(s)) }; os.Exit(s[0]*5) }'
+assert_panic 'panic: runtime error: index out of range [3] with length 3' '[1:103]' 'import "os"; func mk(xs ...int) []int { return xs }; func main() { s := mk(1, 2, 3); i := 3; os.Exit(s[i]) }'
+assert_panic 'panic: runtime error: index out of range [-1]' '[1:104]' 'import "os"; func mk(xs ...int) []int { return xs }; func main() { s := mk(1, 2, 3); i := -1; os.Exit(s[i]) }'
+assert_panic 'panic: runtime error: index out of range [0] with length 0' '[1:42]' 'import "os"; func main() { var s []int; s[0] = 1; os.Exit(0) }'
+assert_error '[1:31]' 'import "os"; func f(a int, xs ...int, b int) {}; func main() { os.Exit(0) }'
+assert_error '[1:27]' 'import "os"; func f(a, xs ...int) {}; func main() { os.Exit(0) }'
+assert_error '[1:23]' 'import "os"; func f() (...int) {}; func main() { os.Exit(0) }'
+assert_error '[1:52]' 'import "os"; func f(xs ...int) {}; func main() { f(3...); os.Exit(0) }'
+assert_error '[1:50]' 'import "os"; func f(xs ...int) {}; func main() { f(1, 2, 3...); os.Exit(0) }'
+assert_error '[1:66]' 'import "os"; func f(xs ...int) {}; func main() { var s []int8; f(s...); os.Exit(0) }'
+assert_error '[1:97]' 'import "os"; func g() (int, int8) { return 1, 2 }; func f(a int, xs ...int) {}; func main() { f(g()); os.Exit(0) }'
+assert_error '[1:100]' 'import "os"; func g() (int, int) { return 1, 2 }; func f(a, b, c int, xs ...int) {}; func main() { f(g()); os.Exit(0) }'
+assert_error '[1:115]' 'import "os"; func f(xs ...int) {}; func mk(xs ...int) []int { return xs }; func main() { s := mk(1); os.Exit(s[0] + s) }'
+assert_error '[1:43]' 'import "os"; func main() { var s []int; s == s; os.Exit(0) }'
+assert_error '[1:37]' 'import "os"; func main() { x := 1; x[0] = 1; os.Exit(0) }'
+assert_error '[1:40]' 'import "os"; func main() { os.Exit(len(1)) }'
+assert 36 "$extern"'func main() { os.Exit(add8(1, 2, 3, 4, 5, 6, 7, 8)) }'
+assert 220 "$extern"'func main() { os.Exit(weigh10(10, 9, 8, 7, 6, 5, 4, 3, 2, 1)) }'
+assert 169 "$extern"'func main() { x := 1; os.Exit(weigh10(x, x+1, x+2, x+3, x+4, x+5, x+6, x+7, x+8, x+9) - add8(1, 1, 1, 1, 1, 1, 1, 1) * 27 + add6(1, 2, 3, 4, 5, 6) - 21) }'
+assert 55 "$extern"'func f(a, b, c, d, e, f, g, h, i, j int) int { return a*1+b*2+c*3+d*4+e*5+f*6+g*7+h*8+i*9+j*10 - weigh10(a, b, c, d, e, f, g, h, i, j) + a+b+c+d+e+f+g+h+i+j }; func main() { os.Exit(f(1, 2, 3, 4, 5, 6, 7, 8, 9, 10)) }'
+assert 28 "$extern"'func f(a, b, c, d, e, f, g int) int { return add8(a, b, c, d, e, f, g, 0) }; func main() { os.Exit(1 + f(1, 2, 3, 4, 5, 6, 7) - 1) }'
+assert 13 'import "os"; func mk(xs ...int) []int { return xs }; func f(a, b int, s []int, t []int) int { return a+b+len(s)*len(t)+t[1] }; func main() { os.Exit(f(1, 2, mk(1, 2), mk(3, 4, 5))) }'
+assert 26 'import "os"; func f(a, b, c, d, e, g int, xs ...int) int { s := a+b+c+d+e+g; for i := 0; i < len(xs); i++ { s += xs[i] }; return s }; func main() { os.Exit(f(1, 1, 1, 1, 1, 1, 10, 10)) }'
+assert 36 "$extern"'func f() (int, int, int, int, int, int, int, int) { return 1, 2, 3, 4, 5, 6, 7, 8 }; func main() { os.Exit(add8(f())) }'
+assert 1 "$extern"'func main() { os.Exit(aligned()) }'
+assert 2 "$extern"'func main() { os.Exit(1 + aligned()) }'
+assert 3 "$extern"'func main() { os.Exit(add(1, add(aligned(), aligned()))) }'
+assert 4 "$extern"'func main() { x := 1; os.Exit(x + add(x, x + aligned())) }'
+assert 9 "$extern"'func main() { os.Exit(add6(1, 1, add(1, aligned()), 1, 1, add8(1, 1, 1, 1, 1, 1, aligned(), 1) - 5)) }'
+assert 6 "$extern"'func f(a, b, c, d, e, g, h int) int { return a+b+c+d+e+g+h }; func main() { os.Exit(f(0, 0, 0, 1, 2, aligned(), add(1, aligned()))) }'
+assert 1 "$extern"'func mk(xs ...int) []int { return xs }; func main() { os.Exit(mk(0, aligned())[1]) }'
+assert 1 "$extern"'func set(p *int) { *p = aligned() }; func f() (n int) { defer set(&n); return 0 }; func main() { os.Exit(f()) }'
+assert 36 'import "os"; func f(p *int, a, b, c, d, e, g, h int) { *p = a+b+c+d+e+g+h+1 }; func g() (n int) { defer f(&n, 2, 3, 4, 5, 6, 7, 8); return }; func main() { os.Exit(g()) }'
+assert 54 'import "os"; func f(p *int, a, b, c, d, e int, s []int) { *p = a+b+c+d+e+len(s)*s[2] }; func mk(xs ...int) []int { return xs }; func g() (n int) { defer f(&n, 1, 2, 3, 4, 5, mk(7, 8, 13)); return }; func main() { os.Exit(g()) }'
+assert 123 'import "os"; func f(p *int, a, b, c, d, e, g int) { *p = *p*10 + g }; func g() (n int) { for i := 1; i <= 3; i++ { defer f(&n, 0, 0, 0, 0, 0, 4-i) }; return }; func main() { os.Exit(g()) }'
+assert 21 "$extern"'func f() (n int) { x := 1; defer weigh10(x, x, x, x, x, x, x, x, x, x); defer add8(x, x, x, x, x, x, x, x); n = 21; return }; func main() { os.Exit(f()) }'
+assert 8 'import "os"
+func h(p *int, a, b, c, d, e, g, h int) (int, int, int, int, int, int, int, int, int, int) { *p = a+h; return 1, 2, 3, 4, 5, 6, 7, 8, 9, 10 }
+func g() (n int) { defer h(&n, 1, 2, 3, 4, 5, 6, 7); defer h(&n, 0, 0, 0, 0, 0, 0, 0); return }; func main() { os.Exit(g()) }'
+assert 34 "$extern"'func f(a, b, c, d, e, g, h int) (int, int, int, int, int, int, int, int, int, int) { return a, b, c, d, e, g, h, a+h, b+g, 10 }
+func main() { x, _, _, _, _, _, y, z, w, v := f(1, 2, 3, 4, 5, 6, 7); os.Exit(x+y+z+w+v+add8(1, 1, 1, 1, 1, 1, 1, 0)-7) }'
+assert 1 "$extern"'func set(p *int, a, b, c, d, e, g int) { *p = aligned() }; func f() (n int) { defer set(&n, 1, 2, 3, 4, 5, 6); return 0 }; func main() { os.Exit(f()) }'
+assert 1 "$extern"'func set(p *int, a, b, c, d, e, g, h int) { *p = aligned() }; func f() (n int) { defer set(&n, 1, 2, 3, 4, 5, 6, 7); return 0 }; func main() { os.Exit(f()) }'
+assert 1 "$extern"'func f(a, b, c, d, e, g, h int) (int, int, int, int, int, int, int, int, int, int) { return a, b, c, d, e, g, h, a, b, aligned() }
+func main() { _, _, _, _, _, _, _, _, _, x := f(1, 2, 3, 4, 5, 6, 7); os.Exit(x) }'
+assert 3 "$extern"'func f(a, b, c, d, e, g int) (int, int, int, int, int, int, int, int, int, int) { return a, b, c, d, e, g, 0, 0, 0, aligned() }
+func main() { x := 1; _, _, _, _, _, _, _, _, _, y := f(1, 2, 3, 4, 5, 6); os.Exit(add(x, add(y, aligned()))) }'
+assert 6 "$extern"'func main() { os.Exit(sumv(3, 1, 2, 3)) }'
+assert 16 "$extern"'func main() { os.Exit(1 + sumv(5, 1, 2, 3, 4, 5)) }'
+assert 37 "$extern"'func main() { x := 7; os.Exit(add(x, sumv(8, 1, 2, 3, 4, x, 6, aligned(), 6))) }'
+assert 1 "$extern"'func main() { if sub(1, 3) == -2 { os.Exit(1) }; os.Exit(0) }'
+assert 8 "$extern"'func main() { os.Exit(int(sub(1, 3)) + 10) }'
+assert 3 'import "os"; func nothing(); func ret3() int; func main() { os.Exit(ret3()) }'
+assert 3 'import "os"; func main() { os.Exit(ret3()) }; func ret3() int'
+assert 7 "$extern"'func main() { x, y := 3, 4; os.Exit(sumv(2, x, y)) }'
+assert 0 "$extern"'func main() { os.Exit(sumv(0)) }'
+assert_error '[1:36]' 'import "os"; func main() { os.Exit(foo()) }'
+assert_error '[15:23]' "$extern"'func main() { os.Exit(add(1)) }'
+assert_error '[15:39]' "$extern"'func main() { var p *int; os.Exit(add(p, 1)) }'
+assert 9 "$extern"'func main() { var x int8 = -1; p := &x; os.Exit(sumv(3, x, 10, p) - sumv(1, p)) }'
+assert 6 "$extern"'func main() { os.Exit(sumslice(1, 2, 3)) }'
+assert 0 "$extern"'func main() { os.Exit(sumslice()) }'
+assert 15 "$extern"'func mk(xs ...int) []int { return xs }; func main() { os.Exit(sumslice(mk(4, 5, 6)...)) }'
+assert 3 'import "os"; func sumslice(xs ...int) int; func main() { os.Exit(sumslice(1, 2)) }'
+assert_error '[15:37]' "$extern"'func main() { var s []int; sumv(1, s...); os.Exit(0) }'
+assert_error '[15:36]' "$extern"'func main() { var s []int; sumv(1, s); os.Exit(0) }'
+assert_error '[15:48]' "$extern"'func main() { var x int8 = 1; os.Exit(sumslice(x)) }'
+assert_error '[1:28]' 'import "os"; func f(a int, ...) int { return a }; func main() { os.Exit(f(1)) }'
+assert_error '[1:21]' 'import "os"; func f(...) int; func main() { os.Exit(f(1)) }'
+assert_error '[1:28]' 'import "os"; func f(a int, ..., b int) int; func main() { os.Exit(f(1)) }'
+assert_error '[15:6]' "$extern"'func add(x, y int) int { return x + y }; func main() { os.Exit(0) }'
+assert 1 'import "os"; func main() { f(); os.Exit(int(f())) }; func f() int8 { return 1 }'
+assert 1 'import "os"
+func main() { os.Exit(isEven(10)) }
+func isEven(n int) int { if n == 0 { return 1 }; return isOdd(n-1) }
+func isOdd(n int) int { if n == 0 { return 0 }; return isEven(n-1) }'
+assert 34 'import "os"; func main() { a, b := f(); os.Exit(a*10+b) }; func f() (int, int) { return 3, 4 }'
+assert 6 'import "os"; func main() { os.Exit(sum(1, 2, 3)) }; func sum(xs ...int) int { s := 0; for i := 0; i < len(xs); i++ { s += xs[i] }; return s }'
+assert_error '[1:36]' 'import "os"; func main() { os.Exit(ret23()) }; func ret32() int { return 32 }'
+assert_error '[1:41]' 'import "os"; func main() { os.Exit(f(1, 2)) }; func f(a int, p *int) int { return a }'
+assert_error '[1:32]' 'import "os"; func f() {}; func f() {}; func main() { os.Exit(0) }'
+assert_error '[1:36]' 'import "os"; func main() { os.Exit(f(1, 2)) }; func f(a int) int { return a }'
+assert 3 'import "os"; var x int; func main() { x = 3; os.Exit(x) }'
+assert 5 'import "os"; var x = 5; func main() { os.Exit(x) }'
+assert 127 'import "os"; var x int8 = 100; func main() { x += 27; os.Exit(int(x)) }'
+assert 76 'import "os"; var a = b + 1; var b = f(); func f() int { return c * 2 }; var c = 3; func main() { os.Exit(a*10 + b) }'
+assert 12 'import "os"
+var (
+  a, b = g()
+  s    []int
+); func g() (int, int) { return 1, 2 }; func main() { os.Exit(a*10 + b + len(s)) }'
+assert 9 'import "os"; var p = &y; var y = 7; func main() { *p = 9; os.Exit(y) }'
+assert 42 'import "os"; var _ = f(); var n int; func f() int { n = 42; return 0 }; func main() { os.Exit(n) }'
+assert 8 'import "os"; var x = 3; func main() { x := 5; os.Exit(x + g()) }; func g() int { return x }'
+assert 12 'import "os"; var order = 0; var a = next(1); var b = next(2); func next(n int) int { order = order*10 + n; return n }; func main() { os.Exit(order) }'
+assert 21 'import "os"; var b = a + 1; var a = next(); var order, c = 0, 1; func next() int { order++; return order + c }; func main() { os.Exit(a*10 + order) }'
+assert_panic 'panic: runtime error: integer divide by zero' '[1:24]' 'import "os"; var x = 1 / zero(); func zero() int { return 0 }; func main() { os.Exit(x) }'
+assert_error '[1:22]' 'import "os"; var x = x; func main() { os.Exit(0) }'
+assert_error '[1:18]' 'import "os"; var a = f(); func f() int { return b }; var b = a; func main() { os.Exit(0) }'
+assert_error '[1:18]' 'import "os"; var a = f(); func f() int { return a }; func main() { os.Exit(0) }'
+assert_error '[1:30]' 'import "os"; var f = 1; func f() int { return 0 }; func main() { os.Exit(0) }'
+assert_error '[1:21]' 'import "os"; var x, x = 1, 2; func main() { os.Exit(0) }'
+assert_error '[1:25]' 'import "os"; var x int8 = y; var y = 1; func main() { os.Exit(0) }'
+assert 5 'import "os"; const x = 5; func main() { os.Exit(x) }'
+assert 123 'import "os"; func main() { const ( a = iota; b; c; d ); os.Exit(a*1000 + b*100 + c*10 + d) }'
+assert 10 'import "os"; const ( _ = iota; KB = 1 << (10 * iota); MB ); func main() { os.Exit(MB/KB/KB*10) }'
+assert 4 'import "os"; const big = 1 << 100; func main() { os.Exit(big >> 98) }'
+assert 25 'import "os"; func main() { os.Exit(-5 * -5) }'
+assert 100 'import "os"; const c int8 = 100; func main() { var x int8 = c; os.Exit(int(x)) }'
+assert 11 'import "os"; const ( a, b = iota, iota * 10; c, d ); func main() { os.Exit(a + b + c + d) }'
+assert 15 'import "os"; func main() { var x uint64 = ^uint64(0); os.Exit(int(x >> 60)) }'
+assert 255 'import "os"; const m = ^uint8(0); func main() { os.Exit(int(m)) }'
+assert 6 'import "os"; const a = b * 2; const b = 3; func main() { os.Exit(a) }'
+assert 2 'import "os"; func main() { const x = 1; { const x = x + 1; os.Exit(x) } }'
+assert 7 'import "os"; var v = c; const c = 7; func main() { os.Exit(v) }'
+assert 3 'import "os"; const ( a int8 = iota + 1; b; c ); func main() { var x int8 = c; os.Exit(int(x)) }'
+assert 1 'import "os"; func main() { var x int = 1 << 62; os.Exit(int(x >> 62)) }'
+assert 1 'import "os"; func main() { const n = -9223372036854775807 - 1; var x = n; os.Exit(int(x >> 63 & 1)) }'
+assert_error '[1:41]' 'import "os"; func main() { var x int8 = 300; os.Exit(int(x)) }'
+assert_error '[1:45]' 'import "os"; func main() { os.Exit(int(int8(200))) }'
+assert_error '[1:62]' 'import "os"; const c int8 = 100; func main() { os.Exit(int(c + 100)) }'
+assert_error '[1:56]' 'import "os"; func main() { var x int8; os.Exit(int(x + 1000)) }'
+assert_error '[1:38]' 'import "os"; func main() { os.Exit(1 << 64) }'
+assert_error '[1:63]' 'import "os"; const x uint8 = 255; func main() { os.Exit(int(x + 1)) }'
+assert_error '[1:46]' 'import "os"; func main() { x := 1; const y = x; os.Exit(y) }'
+assert_error '[1:36]' 'import "os"; func main() { os.Exit(iota) }'
+assert_error '[1:24]' 'import "os"; const a = a; func main() { os.Exit(0) }'
+assert_error '[1:34]' 'import "os"; func main() { const a; os.Exit(0) }'
+assert_error '[1:38]' 'import "os"; func main() { os.Exit(1 / 0) }'
+assert_error '[1:46]' 'import "os"; func main() { x := 5; os.Exit(x / 0) }'
+assert_error '[1:46]' 'import "os"; func main() { x := 5; os.Exit(x % 0) }'
+assert_error '[1:38]' 'import "os"; func main() { x := 5; x %= 0; os.Exit(x) }'
+assert_error '[1:38]' 'import "os"; func main() { x := 5; x /= 0; os.Exit(x) }'
+assert_error '[1:62]' 'import "os"; const zero = 0; func main() { x := 5; os.Exit(x / zero) }'
+assert 15 'import "os"; func main() { s := 60; var u uint64 = (1<<64 - 1) >> s; os.Exit(int(u)) }'
+assert 72 'import "os"; func main() { s := 7; var x int8 = 1 << s; os.Exit(int(x) + 200) }'
+assert 254 'import "os"; func main() { s := 0; var x uint8 = ^(1 << s); os.Exit(int(x)) }'
+assert 1 'import "os"; func main() { s := 3; if 1<<s == 8 { os.Exit(1) }; os.Exit(0) }'
+assert 1 'import "os"; func main() { s := 62; x := 1 << s; os.Exit(x >> 62) }'
+assert 4 'import "os"; func f(x uint8) int { return int(x) }; func main() { s := 6; os.Exit(f(1<<s + 1<<s) / 32) }'
+assert_error '[1:49]' 'import "os"; func main() { s := 1; var x int8 = 255 >> s; os.Exit(int(x)) }'
+assert_error '[1:53]' 'import "os"; func main() { s := 1; os.Exit(int(int8(200 >> s))) }'
+assert_error '[1:69]' 'import "os"; func main() { s := 1; var x uint8 = 1; os.Exit(int(x + 256>>s)) }'
+assert_error '[1:51]' 'import "os"; func main() { var s []int; os.Exit(s[-1]) }'
+assert_error '[1:41]' 'import "os"; func main() { os.Exit(1 >> -1) }'
+assert_error '[1:39]' 'import "os"; func main() { const a, b = 1; os.Exit(0) }'
+assert_error '[1:41]' 'import "os"; func main() { const x = 1; x = 2; os.Exit(0) }'
+assert 123 'import "os"; var x = f(); func f() int { return 1 }; func init() { x = x*10 + 2 }; func init() { x = x*10 + 3 }; func main() { os.Exit(x) }'
+assert 5 'import "os"; func init() { n = 5 }; var n int; func main() { os.Exit(n) }'
+assert 3 'import "os"; func init() { init := 3; n = init }; var n int; func main() { os.Exit(n) }'
+assert_panic 'panic: runtime error: integer divide by zero' '[1:45]' 'import "os"; var z int; func init() { z = 1 / z }; func main() { os.Exit(z) }'
+assert_error '[1:28]' 'import "os"; func init() { init() }; func main() { os.Exit(0) }'
+assert_error '[1:19]' 'import "os"; func init(x int) {}; func main() { os.Exit(0) }'
+assert_error '[1:19]' 'import "os"; func init() int { return 0 }; func main() { os.Exit(0) }'
+assert_error '[1:19]' 'import "os"; func init(); func main() { os.Exit(0) }'
+assert_error '[1:18]' 'import "os"; var init = 1; func main() { os.Exit(0) }'
+assert 0 'func main() {}'
+assert 0 'func main() { return }'
+assert 0 'func main() { var a = 3; if a > 0 { return }; a = 1 }'
+assert 7 'import "os"; func main() { os.Exit(7) }'
+assert 7 'import ( "os" ); func main() { os.Exit(7) }'
+assert 3 'import "os"; func f() int { os.Exit(3); return 1 }; func main() { f(); os.Exit(4) }'
+assert 0 "$extern"'func main() { printint(42); printint(-1); os.Exit(0) }' '42
+-1'
+assert 5 "$extern"'func main() { defer printint(2); printint(1); os.Exit(5) }' '1'
+assert 0 "$extern"'func f() { defer printint(2); printint(1) }; func main() { f(); os.Exit(0) }' '1
+2'
+assert 0 "$extern"'var n = printint(1); func init() { printint(2) }; func main() { printint(3); os.Exit(0) }' '1
+2
+3'
+assert 0 "$extern"'func main() { if bufint(7) < 0 { os.Exit(1) } }' '7'
+assert 0 "$extern"'func f() { defer bufint(2); bufint(1) }; func main() { f(); if bufint(3) < 0 { os.Exit(1) } }' '1
+2
+3'
+assert_error '[1:6]' 'func main() int { return 0 }'
+assert_error '[1:6]' 'func main(n int) {}'
+assert_error '[1:6]' 'func main()'
+assert_error '[2:1]' 'func f() {}'
+assert_error '[1:15]' 'func main() { return 1 }'
+assert_error '[1:8]' 'import "os"; func main() {}'
+assert_error '[1:8]' 'import "fmt"; func main() {}'
+assert_error '[1:21]' 'import "os"; import "os"; func main() { os.Exit(0) }'
+assert_error '[1:17]' 'func main() {}; import "os"'
+assert_error '[1:15]' 'func main() { os.Exit(0) }'
+assert_error '[1:31]' 'import "os"; func main() { os.Quit(0) }'
+assert_error '[1:28]' 'import "os"; func main() { os.Exit(1, 2) }'
+assert_error '[1:36]' 'import "os"; func main() { var a = os.Exit(1) }'
+assert_error '[1:8]' 'import "os'
+
+assert_freestanding 0 'func main() {}'
+assert_freestanding 42 'import "os"; func main() { os.Exit(42) }'
+assert_freestanding 7 'import "os"; var a = f(); func f() int { return 3 }; func init() { a += 4 }; func main() { os.Exit(a) }'
+assert_freestanding 3 'import "os"; func f() { defer g(); }; func g() { os.Exit(3) }; func main() { f(); os.Exit(1) }'
+assert_freestanding 2 'func main() { var a = 0; a = 1 / a }'
+assert_freestanding 6 'import "os"; func mk(xs ...int) []int { return xs }; func main() { s := mk(1, 2, 3); os.Exit(s[0]+s[1]+s[2]) }'
+
+assert_print '42' 'func main() { println(42) }'
+assert_print '1 -2 300' 'func main() { println(1, -2, 300) }'
+assert_print '1-2300' 'func main() { print(1, -2, 300) }'
+assert_print '' 'func main() { print() }'
+assert_print '
+5' 'func main() { println(); print(5) }'
+assert_print '12
+3' 'func main() { print(1); print(2); println(); print(3) }'
+assert_print '9223372036854775807 -9223372036854775808' 'func main() { println(1<<63-1, -1<<63) }'
+assert_print '18446744073709551615 255 -128' 'func main() { var u uint64 = 1<<64-1; var b byte = 255; var i int8 = 127; i++; println(u, b, i) }'
+assert_print 'true false true' 'func main() { a := 3; println(a == 3, a < 2, a > 2 && a < 4) }'
+assert_print 'true false' 'func main() { println(true, false) }'
+assert_print 'truefalse' 'func main() { const c = 1 < 2; print(c, c == false) }'
+assert_print 'true false true' 'func main() { a := 3; b := a == 3; var c bool; d := b || c; println(b, c, d) }'
+assert_print 'false true' 'var g = f(); func f() bool { return 2 > 3 }; func main() { var h bool = true; println(g, h && g == false) }'
+assert_print 'true' 'func main() { b := bool(1 < 2); println(b) }'
+assert 1 'import "os"; func main() { var b bool; for i := 0; i < 3; i++ { b = b == false || i == 2 }; if b { os.Exit(1) } }'
+assert_error '[1:33]' 'func main() { a := 3; var n int = a == 3; println(n) }'
+assert_error '[1:57]' 'import "os"; func main() { a := 3; b := a == 3; os.Exit(b) }'
+assert_error '[1:47]' 'import "os"; func main() { a := 3; os.Exit(int(a == 3)) }'
+assert_error '[1:28]' 'func main() { var b bool; b++ }'
+assert_error '[1:36]' 'func main() { var b bool = true; b = 1; println(b) }'
+assert_error '[1:44]' 'func main() { var b bool = true; println(b == 1) }'
+assert_error '[1:33]' 'func main() { a := 1; println(a && true) }'
+assert_error '[1:38]' 'func main() { a := 1; println(a == 1 || a) }'
+assert_error '[1:25]' 'func main() { println(1 && 0) }'
+assert_error '[1:26]' 'func main() { a := 1; if a { println(a) } }'
+assert_error '[1:27]' 'func main() { for i := 0; i; i++ {} }'
+assert_error '[1:29]' 'func main() { a := 0; for a + 1 {} }'
+assert_print '0x0' 'func main() { var p *int; println(p) }'
+assert_print 'true' 'func main() { a := 1; p := &a; q := &a; println(p == q) }'
+assert_print '[0/0]0x0' 'func main() { var s []int; println(s) }'
+assert_print '1236' 'func f(n int) int { print(n); return n }; func main() { println(f(1) + f(2) + f(3)) }'
+assert_print '1000' 'func f() { println(1000) }; func main() { defer f() }'
+assert_print '256' "$extern"'func main() { println(add(200, 56)); os.Exit(0) }'
+assert_freestanding 0 'func main() { println(1, 2) }'
+assert_error '[1:20]' 'func main() { a := println(1) }'
+assert_error '[1:60]' 'func f() (int, int) { return 1, 2 }; func main() { println(f()) }'
+assert_error '[1:24]' 'func main() { println(1<<63) }'
+assert_error '[1:23]' 'func main() { println(x) }'
+
+assert_print '0 7' 'func main() { p := new(int); q := new(int); *q = 7; println(*p, *q) }'
+assert_print '0 6' 'func main() { p := new(*int); s := new([]int); x := 6; *p = &x; println(len(*s), **p) }'
+assert_print 'true' 'func main() { p := new(int,); println(p != new(int)) }'
+assert_print '8 5 3' 'func f(n int) *int { x := n; return &x }; func g(a, b int) int { c := a + b; return c }; func main() { p := f(5); q := f(3); g(100, 200); println(*p + *q, *p, *q) }'
+assert_print '4 5' 'func f(n int) *int { return &n }; func main() { p := f(4); q := f(5); println(*p, *q) }'
+assert_print '7' 'func f(a, b, c, d, e, f, g int) *int { return &g }; func main() { p := f(1, 2, 3, 4, 5, 6, 7); f(0, 0, 0, 0, 0, 0, 0); println(*p) }'
+assert_print '3 4' 'func f() (r int, p *int) { p = &r; r = 3; return }; func main() { a, p := f(); *p = 4; println(a, *p) }'
+assert_print '1 2' 'func f() **int { x := 1; p := &x; return &p }; func main() { a := f(); b := f(); **b = 2; println(**a, **b) }'
+assert_print '1 2' 'var g *int; func set(p *int) { g = p }; func main() { x := 1; set(&x); y := 2; println(*g, y) }'
+assert_print '2 3' 'func mk(xs ...int) []int { return xs }; func f() *[]int { s := mk(1, 2); return &s }; func main() { p := f(); f(); s := *p; println(len(*p), s[0] + s[1]) }'
+assert_error '[1:32]' 'func main() { x := 1; p := new(x); println(*p) }'
+assert_escape '' 'func main() { x := 1; p := &x; *p = 2; println(x) }'
+assert_escape '' 'func id(p *int) int { return *p }; func main() { x := 1; println(id(&x)) }'
+assert_escape '' 'func main() { p := new(int); q := &p; println(**q) }'
+assert_escape '[1:17] 変数xをヒープに割り当てます(アドレスが関数の結果として返されます)' 'func f() *int { x := 5; return &x }; func main() { println(*f()) }'
+assert_escape '[1:8] 変数nをヒープに割り当てます(アドレスが関数の結果として返されます)' 'func f(n int) *int { return &n }; func main() { println(*f(1)) }'
+assert_escape '[1:11] 変数rをヒープに割り当てます(アドレスが関数の結果として返されます)' 'func f() (r int, p *int) { p = &r; return }; func main() { a, p := f(); println(a, *p) }'
+assert_escape '[1:27] 変数xをヒープに割り当てます(アドレスがパッケージレベルの変数gに代入されます)' 'var g *int; func main() { x := 1; g = &x; println(*g) }'
+assert_escape '[1:31] 変数xをヒープに割り当てます(アドレスがポインタの指す先に代入されます)' 'func main() { p := new(*int); x := 1; *p = &x; println(**p) }'
+assert_escape '[1:55] 変数xをヒープに割り当てます(アドレスが関数setの引数からヒープに流れ込みます)' 'var g *int; func set(p *int) { g = p }; func main() { x := 1; set(&x); println(*g) }'
+assert_escape '[1:88] 変数xをヒープに割り当てます(アドレスが関数fの引数からヒープに流れ込みます)' 'func f(p *int, n int) *int { if n == 0 { return p }; return f(p, n-1) }; func main() { x := 1; println(*f(&x, 3)) }'
+assert_escape '[1:38] 変数aをヒープに割り当てます(アドレスが可変長引数のスライスに格納されます)' 'func f(xs ...*int) {}; func main() { a := 1; f(&a) }'
+assert_escape '' "$extern"'func main() { a := 1; var p *int = &a; os.Exit(add(*p, 1)) }'
+assert_escape '[15:15] 変数aをヒープに割り当てます(アドレスが外部の関数derefに渡されます)' "$extern"'func main() { a := 1; os.Exit(deref(&a)) }'
+assert_escape '[1:18] 変数xをヒープに割り当てます(アドレスがヒープに割り当てる変数pに代入されます)
+[1:26] 変数pをヒープに割り当てます(アドレスが関数の結果として返されます)' 'func f() **int { x := 1; p := &x; return &p }; func main() { println(**f()) }'
+assert_escape '[1:19] 変数iをヒープに割り当てます(繰り返しごとに別の変数になるループ変数のアドレスが取られています)' 'func main() { for i := 0; i < 2; i++ { p := &i; println(*p) } }'
+
+assert_print '3 5 0 9 11' 'import "runtime"; var keep *int; func mk(xs ...*int) []*int { return xs }; func main() { keep = new(int); *keep = 3; p := new(int); *p = 5; s := mk(new(int), new(int)); *s[1] = 9; pp := new(*int); *pp = new(int); **pp = 11; for i := 0; i < 1000000; i++ { q := new(int); *q = i }; runtime.GC(); for i := 0; i < 1000000; i++ { q := new(int); *q = -1 }; println(*keep, *p, *s[0], *s[1], **pp) }'
+GODEBUG=gctrace=1 assert_print '' 'import "runtime"; func main() { runtime.GC() }'
+GODEBUG=gctrace=1 assert_print 'gc 1: 32->32 B, 4194304 B goal (forced)
+gc 2: 32->32 B, 4194304 B goal (forced)
+4' 'import "runtime"; func main() { p := new(int); *p = 4; runtime.GC(); runtime.GC(); println(*p) }'
+GODEBUG=gctrace=1 assert_print 'gc 1: 4194304->0 B, 4194304 B goal
+gc 2: 4194304->0 B, 4194304 B goal' 'func main() { for i := 0; i < 300000; i++ { new(int) } }'
+GODEBUG=gctrace=1 GOGC=off assert_print '' 'func main() { for i := 0; i < 300000; i++ { new(int) } }'
+GODEBUG=gctrace=1 assert_print 'gc 1: 4194304->4194304 B, 8388608 B goal
+gc 2: 8388608->6400128 B, 12800256 B goal
+gc 3: 12800256->6400128 B, 12800256 B goal
+1250325000' 'func f(n int) int { p, q, r, s := new(int), new(int), new(int), new(int); *p, *q, *r, *s = n, 1, 2, 3; if n == 0 { for i := 0; i < 300000; i++ { new(int) }; return 0 }; return f(n-1) + *p + *q + *r + *s }; func main() { println(f(50000)) }'
+GODEBUG=gctrace=1 GOGC=50 assert_print 'gc 1: 4194304->4194304 B, 6291456 B goal
+gc 2: 6291456->6291456 B, 9437184 B goal
+gc 3: 9437184->6400128 B, 9600192 B goal
+gc 4: 9600192->6400128 B, 9600192 B goal
+gc 5: 9600192->6400128 B, 9600192 B goal
+1250325000' 'func f(n int) int { p, q, r, s := new(int), new(int), new(int), new(int); *p, *q, *r, *s = n, 1, 2, 3; if n == 0 { for i := 0; i < 300000; i++ { new(int) }; return 0 }; return f(n-1) + *p + *q + *r + *s }; func main() { println(f(50000)) }'
+assert_print '8 5' 'import "runtime"
+func mk(xs ...int) []int { return xs }
+func newint(n int) *int { p := new(int); *p = n; return p }
+func f(a, b, c, d, e, g, h, i, j, k, l, m, n, o, q, r, s, t, u, v, w, x, y, z []int, p *int) { println(*p, z[0]) }
+func g() { var e []int; defer f(e, e, e, e, e, e, e, e, e, e, e, e, e, e, e, e, e, e, e, e, e, e, e, mk(5), newint(8)); for i := 0; i < 1000000; i++ { new(int) }; runtime.GC(); for i := 0; i < 1000000; i++ { x := new(int); *x = -1 } }
+func main() { g() }'
+assert_freestanding 7 'import ("os"; "runtime"); func main() { p := new(int); *p = 7; for i := 0; i < 1000000; i++ { new(int) }; runtime.GC(); os.Exit(*p) }'
+
+assert_print '21 22' 'func main() { k := 10; f := func(x int) int { return x + k }; k = 20; println(f(1), f(2)) }'
+assert_print '3 1' 'func counter() func() int { n := 0; return func() int { n++; return n } }; func main() { c := counter(); d := counter(); c(); c(); println(c(), d()) }'
+assert_print '6765' 'func main() { var fib func(int) int; fib = func(n int) int { if n < 2 { return n }; return fib(n-1) + fib(n-2) }; println(fib(20)) }'
+assert_print '6' 'func main() { x := 1; func() { func() { x += 5 }() }(); println(x) }'
+assert_print '2 1' 'func main() { x := 1; defer func(y int) { println(x, y) }(x); x = 2 }'
+assert_print '2
+1
+0' 'func main() { for i := 0; i < 3; i++ { defer func() { println(i) }() } }'
+assert_print '21' 'func apply(f func(int) int, x int) int { return f(x) }; func main() { a := 3; println(apply(func(x int) int { return x * a }, 7)) }'
+assert_print '9' 'func main() { f := func(x int, y func(int) int) func() int { return func() int { return y(x) } }; println(f(3, func(a int) int { return a * a })()) }'
+assert_print '42' 'var g = func(x int) int { return x * 2 }; func main() { println(g(21)) }'
+assert_print '9 7' 'func main() { f := func(a, b, c, d, e, f, g, h int) (int, int) { return a+h, g }; x, y := f(1, 2, 3, 4, 5, 6, 7, 8); println(x, y) }'
+assert_print '1 8 9 10 5' 'func main() { f := func(a, b, c, d, e, g, h, i int) (int, int, int, int, int, int, int, int, int, int) { return a, b, c, d, e, g, h, i, a+i, 10 }; x := 5; a, _, _, _, _, _, _, i, j, k := f(1, 2, 3, 4, 5, 6, 7, 8); println(a, i, j, k, x) }'
+assert_print '6' 'func main() { s := 0; add := func(xs ...int) { for i := 0; i < len(xs); i++ { s += xs[i] } }; add(1, 2, 3); add(); println(s) }'
+assert_print '3' 'func f() int { return 1 }; func main() { f := func() int { return 3 }; println(f()) }'
+assert_print '101 6 102' 'import "runtime"; var keep func() int; func counter(start int) func() int { n := new(int); *n = start; return func() int { *n++; return *n } }; func main() { keep = counter(100); c := counter(5); for i := 0; i < 300000; i++ { counter(i)() }; runtime.GC(); for i := 0; i < 300000; i++ { new(int) }; println(keep(), c(), keep()) }'
+assert_freestanding 6 'import "os"; func main() { k := 3; f := func() int { return k * 2 }; defer func() { os.Exit(f()) }() }'
+assert_error '[1:35]' 'func main() { var f func(int) int = func(x int) int32 { return 1 }; println(f(1)) }'
+assert_error '[1:24]' 'func main() { x := 1; x(2) }'
+assert_error '[1:41]' 'func main() { f := func() {}; println(f == f) }'
+assert_error '[1:36]' 'func main() { f := func() {}; x := f() }'
+assert_escape '[1:15] 変数xをヒープに割り当てます(関数リテラルに捕捉されています)' 'func main() { x := 1; f := func() int { return x }; println(f()) }'
+assert_escape '[1:15] 変数xをヒープに割り当てます(アドレスがヒープに割り当てる変数pに代入されます)
+[1:23] 変数pをヒープに割り当てます(関数リテラルに捕捉されています)' 'func main() { x := 1; p := &x; f := func() int { return *p }; println(f()) }'
+assert_escape '[1:15] 変数yをヒープに割り当てます(アドレスが関数値の呼び出しに渡されます)' 'func main() { y := 1; f := func(p *int) int { return *p }; println(f(&y)) }'
+assert_escape '[1:19] 変数qをヒープに割り当てます(関数リテラルに捕捉されています)
+[1:41] 変数yをヒープに割り当てます(アドレスが関数リテラルが捕捉した変数qに代入されます)' 'func main() { var q *int; f := func() { y := 1; q = &y }; f(); println(*q) }'
+
+assert_print '10
+4
+21' 'func add(a, b int) int { return a + b }; func sub(a, b int) int { return a - b }; func mk(fs ...func(int, int) int) []func(int, int) int { return fs }; func main() { ops := mk(add, sub, func(a, b int) int { return a * b }); for i := 0; i < len(ops); i++ { println(ops[i](7, 3)) } }'
+assert_print '7' 'func twice(f func(int) int, x int) int { return f(f(x)) }; func inc(x int) int { return x + 1 }; func main() { println(twice(inc, 5)) }'
+assert_print 'true true
+false
+true true true' 'func main() { var f func() int; println(f == nil, nil == f); f = func() int { return 1 }; println(f == nil); var s []int; var p *int; println(s == nil, p == nil, nil == p) }'
+assert_print 'true 4' 'var p, q *int = nil, new(int); func main() { *q = 4; println(p == nil, *q) }'
+assert_print 'true' 'func f() func() int { return nil }; func main() { println(f() == nil) }'
+assert_print '1' 'func f(p *int, s []int, g func()) int { if p == nil && s == nil && g == nil { return 1 }; return 0 }; func main() { println(f(nil, nil, nil)) }'
+assert 3 "$extern"'func main() { f := ret3; os.Exit(f()) }'
+assert 3 'import "os"; func main() { exit := os.Exit; defer exit(3) }'
+assert_panic 'panic: runtime error: invalid memory address or nil pointer dereference' '[1:36]' 'func main() { var f func(int) int; f(1) }'
+assert_panic 'panic: runtime error: invalid memory address or nil pointer dereference' '[1:29]' 'func main() { var f func(); defer f() }'
+assert_panic 'panic: runtime error: invalid memory address or nil pointer dereference' '[1:40]' 'func main() { var f func(int); x := 1; defer f(x); f = func(int) {} }'
+assert_error '[1:17]' 'func main() { x := nil }'
+assert_error '[1:27]' 'func main() { println(nil == nil) }'
+assert_error '[1:25]' 'func main() { var x int = nil }'
+assert_error '[1:28]' 'func main() { println(1 == nil) }'
+assert_error '[1:51]' 'func main() { var s []int; var t []int; println(s == t) }'
+assert_error '[15:20]' "$extern"'func main() { f := sumv; _ = f }'
+
+assert_print '3 5 3' 'type P struct { x, y int }; func main() { var p P; p.x = 3; p.y = 4; q := &p; q.y++; println(p.x, p.y, q.x) }'
+assert_print '1 3 0 true' 'type S struct { a int8; b []int; c int32; d *int }; func mk() S { var s S; s.a = 1; s.c = 3; return s }; func main() { println(mk().a, mk().c, len(mk().b), mk().d == nil) }'
+assert_print '4999950000' 'type L struct { next *L; v int }; func main() { var h *L; for i := 0; i < 100000; i++ { n := new(L); n.next = h; n.v = i; h = n }; s := 0; for p := h; p != nil; p = p.next { s += p.v }; println(s) }'
+assert_print '2 20' 'type C int; func (c *C) Inc() { *c++ }; func (c C) Ten() int { return int(c) * 10 }; func main() { var c C; c.Inc(); c.Inc(); println(int(c), c.Ten()) }'
+assert_print '7 7 7' 'type Base struct { id int }; func (b Base) ID() int { return b.id }; func (b *Base) SetID(id int) { b.id = id }; type Mid struct { *Base }; type Outer struct { Mid; x int8 }; func main() { var o Outer; o.Base = new(Base); o.SetID(7); println(o.id, o.ID(), o.Mid.Base.id) }'
+assert_print '3 9 9' 'type A struct { x int }; type B struct { A }; type C struct { B; x int8 }; func main() { var c C; c.x = 3; c.B.x = 9; println(c.x, c.B.x, c.A.x) }'
+assert_print '7
+7
+20' 'type Named interface { ID() int }; type Base struct { id int }; func (b *Base) ID() int { return b.id }; type Outer struct { *Base }; type C int; func (c C) ID() int { return int(c) * 10 }; func show(n Named) { println(n.ID()) }; func main() { var o Outer; o.Base = new(Base); o.id = 7; show(o); show(&o); show(C(2)) }'
+assert_print 'true
+false 20
+20' 'type I interface { M() int }; type C int; func (c C) M() int { return int(c) * 10 }; func main() { var i I; println(i == nil); i = C(2); defer func() { println(i.M()) }(); println(i == nil, i.M()) }'
+assert_print '72 2 42
+12 0' 'type Adder interface { Add(a, b, c, d, e, f int, rest ...int) (int, int) }; type Namer interface { Adder; Name() int }; type Impl struct { k int }; func (m *Impl) Add(a, b, c, d, e, f int, rest ...int) (int, int) { s := a + b + c + d + e + f; for i := 0; i < len(rest); i++ { s += rest[i] }; return s * m.k, len(rest) }; func (m *Impl) Name() int { return 42 }; type Wrap struct { Namer }; func main() { im := new(Impl); im.k = 2; var w Wrap; w.Namer = im; x, n := w.Add(1, 2, 3, 4, 5, 6, 7, 8); println(x, n, w.Name()); var a Adder = w; y, m := a.Add(1, 1, 1, 1, 1, 1); println(y, m) }'
+assert_print '0 1 2' 'import "runtime"; type V interface { Get() int }; type Box struct { p *int; pad int }; func (b Box) Get() int { return *b.p }; func mk(i int) V { x := new(int); *x = i; var b Box; b.p = x; return b }; func main() { a, b := mk(1), mk(2); s := 0; for i := 0; i < 200000; i++ { v := mk(i); s += v.Get() - i }; runtime.GC(); println(s, a.Get(), b.Get()) }'
+assert_print '6' 'type V interface { Get() int }; type T struct { p *int }; func (t T) Get() int { return *t.p + n }; var v V = mk(); var n = 5; func mk() V { var t T; t.p = new(int); *t.p = 1; return t }; func main() { println(v.Get()) }'
+assert_panic 'panic: runtime error: invalid memory address or nil pointer dereference' '[1:52]' 'type I interface { M() }; func main() { var i I; i.M() }'
+assert_panic 'panic: runtime error: invalid memory address or nil pointer dereference' '[1:71]' 'type I interface { M() }; func main() { defer f(nil) }; func f(i I) { defer i.M() }'
+assert_escape '[1:46] 変数pをヒープに割り当てます(アドレスが関数の結果として返されます)' 'type P struct { x int }; func f() *int { var p P; return &p.x }; func main() { println(*f()) }'
+assert_escape '[1:98] 変数xをヒープに割り当てます(アドレスがインターフェースのメソッドの呼び出しに渡されます)' 'type I interface { M(p *int) }; type T int; func (T) M(p *int) {}; func main() { var i I = T(0); x := 1; i.M(&x) }'
+assert_error '[1:108]' 'type A struct { x int }; type B struct { x int }; type C struct { A; B }; func main() { var c C; println(c.x) }'
+assert_error '[1:95]' 'type I interface { M() }; type T struct{}; func (t *T) M() {}; func main() { var t T; var i I = t; _ = i }'
+assert_error '[1:92]' 'type T struct{}; func (t *T) M() {}; func mk() T { var t T; return t }; func main() { mk().M() }'
+assert_error '[1:58]' 'type T struct{ x int }; func main() { var t T; println(t.y) }'
+assert_error '[1:42]' 'type T struct { u U }; type U struct { t T }; func main() {}'
+assert_error '[1:36]' 'type T struct{ M int }; func (t T) M() {}; func main() {}'
+assert_error '[1:67]' 'type T struct{}; func (t T) M() {}; func main() { var t T; f := t.M; _ = f }'
+assert_error '[1:15]' 'func main() { type T int }'
+assert_error '[1:9]' 'func (x int) M() {}; func main() {}'
+assert_error '[1:54]' 'type T struct{}; func main() { var a, b T; println(a == b) }'
+assert_error '[1:129]' 'type I interface{ M() }; type T int; func (T) M() {}; func f() (T, int) { return 1, 2 }; func g(a I, b int) {}; func main() { g(f()) }'
 # fibonacci = [0,1,1,2,3,5,8,13,21,34,55]
-assert 55 'func fib_for(n int) int {
+assert 55 'import "os"
+func fib_for(n int) int {
   var a int = 0
   var b int = 1
   var i int
-  for i = 0; i<n; i = i + 1{
-    b = a+b
-    a = b-a
+  for i = 0; i<n; i++ {
+    a, b = b, a+b
   }
   return a
 }
 
 func main() {
-  return fib_for(10)
+  os.Exit(fib_for(10))
 }
 '
-assert 55 'func fib_rec(n int) int {
+assert 55 'import "os"
+func fib_rec(n int) int {
   if n == 0 {
     return 0
   }
@@ -131,13 +866,13 @@ assert 55 'func fib_rec(n int) int {
 }
 
 func main() {
-  return fib_rec(10)
+  os.Exit(fib_rec(10))
 }
 '
 
-assert 3 'func main() { var x int = 3; return *&x; }'
-assert 3 'func main() { var x int = 3; return *&*&x; }'
-assert 3 'func main() { var x int = 3; var y = &x; var z = &y; return **z; }'
-assert 5 'func main() { var x int = 3; var y = &x; *y = 5; return x; }'
+assert 3 'import "os"; func main() { var x int = 3; os.Exit(*&x); }'
+assert 3 'import "os"; func main() { var x int = 3; os.Exit(*&*&x); }'
+assert 3 'import "os"; func main() { var x int = 3; var y = &x; var z = &y; os.Exit(**z); }'
+assert 5 'import "os"; func main() { var x int = 3; var y = &x; *y = 5; os.Exit(x); }'
 
 printf '\033[32m%s\033[m\n' 'OK'
@@ -6,6 +6,7 @@ const (
 	TK_RESERVED TokenKind = iota // Keywords or punctuators
 	TK_IDENT                     // Identifier
 	TK_NUM                       // Numeric literals
+	TK_STR                       // String literals
 	TK_EOF                       // End-of-file markers
 )
 
@@ -69,7 +70,7 @@ func isPunct(c byte) bool {
 }
 
 func isKeywords(ident string) bool {
-	keywords := []string{"return", "if", "else", "for", "func"}
+	keywords := []string{"return", "if", "else", "for", "func", "defer", "const", "import", "type", "struct", "interface"}
 	return contains(keywords, ident)
 }
 
@@ -117,7 +118,7 @@ func (tn *Tokenizer) tokenize() []*Token {
 			keywords := []string{"break", "continue", "fallthrough", "return", "++", "--", ")", "]", "}"}
 			tk := tn.tokens[len(tn.tokens)-1] // 改行直前のトークン
 			// 特定の条件でセミコロンを自動挿入する
-			if tk.line == tn.line && (tk.kind == TK_IDENT || tk.kind == TK_NUM || contains(keywords, tk.val)) {
+			if tk.line == tn.line && (tk.kind == TK_IDENT || tk.kind == TK_NUM || tk.kind == TK_STR || contains(keywords, tk.val)) {
 				semicolon := &Token{kind: TK_RESERVED, line: tn.line, col: tn.col + 1, val: ";"}
 				tn.tokens = append(tn.tokens, semicolon)
 			}
@@ -131,6 +132,20 @@ func (tn *Tokenizer) tokenize() []*Token {
 				token.val += tn.read(1)
 			}
 			tn.tokens = append(tn.tokens, token)
+		case c == '"': // String literal
+			token := &Token{kind: TK_STR, line: tn.line, col: tn.col}
+			tn.read(1)
+			for !tn.startswith("\"") {
+				if tn.startswith("\\") {
+					error_at(tn.code, tn.line, tn.col, "エスケープシーケンスには対応していません")
+				}
+				if tn.i == len(tn.code) || tn.startswith("\n") {
+					error_at(tn.code, token.line, token.col, "文字列リテラルが閉じられていません")
+				}
+				token.val += tn.read(1)
+			}
+			tn.read(1)
+			tn.tokens = append(tn.tokens, token)
 		case isLetter(c): // Keywords or local variables
 			token := &Token{kind: TK_IDENT, line: tn.line, col: tn.col}
 			token.val = tn.read(1)
@@ -141,7 +156,11 @@ func (tn *Tokenizer) tokenize() []*Token {
 				token.kind = TK_RESERVED
 			}
 			tn.tokens = append(tn.tokens, token)
-		case contains([]string{"==", "!=", "<=", ">="}, tn.peek(2)): // Multi-letter punctuators
+		case contains([]string{"<<=", ">>=", "&^=", "..."}, tn.peek(3)): // Three-letter punctuators
+			token := &Token{kind: TK_RESERVED, line: tn.line, col: tn.col}
+			token.val = tn.read(3)
+			tn.tokens = append(tn.tokens, token)
+		case contains([]string{"==", "!=", "<=", ">=", ":=", "++", "--", "+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=", "<<", ">>", "&^", "&&", "||"}, tn.peek(2)): // Two-letter punctuators
 			token := &Token{kind: TK_RESERVED, line: tn.line, col: tn.col}
 			token.val = tn.read(2)
 			tn.tokens = append(tn.tokens, token)
@@ -0,0 +1,610 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+type TypeKind int
+
+const (
+	TY_INT          TypeKind = iota // Integer types
+	TY_PTR                          // Pointer
+	TY_UNTYPED_INT                  // Untyped integer constant
+	TY_FUNC                         // Function
+	TY_SLICE                        // Slice
+	TY_TUPLE                        // Results of function call except single value
+	TY_BOOL                         // Boolean
+	TY_UNTYPED_BOOL                 // Untyped boolean (constant or result of comparison)
+	TY_NIL                          // Untyped nil
+	TY_STRUCT                       // Struct
+	TY_INTERFACE                    // Interface
+)
+
+type Type struct {
+	kind       TypeKind // Type kind
+	name       string   // Type name
+	size       int      // sizeof() value
+	unsigned   bool     // Used if kind == TY_INT
+	base       *Type    // Used if kind == TY_PTR or TY_SLICE
+	params     []*Type  // Used if kind == TY_FUNC
+	results    []*Type  // Used if kind == TY_FUNC or TY_TUPLE
+	variadic   bool     // Used if kind == TY_FUNC
+	cvariadic  bool     // Used if kind == TY_FUNC
+	fields     []*Field // Used if kind == TY_STRUCT or TY_INTERFACE
+	defined    bool     // Defined by a type declaration
+	underlying *Type    // Used if defined
+	methods    []*Node  // Used if defined
+}
+
+// 構造体のフィールドまたはインターフェースのメソッド
+type Field struct {
+	name     string
+	ty       *Type
+	offset   int    // 構造体の先頭からのバイト数 インターフェースのメソッドならメソッドの表の中のバイト数
+	embedded bool   // 型名だけで宣言した埋め込みフィールド
+	token    *Token // 宣言した位置
+}
+
+var (
+	ty_int8    = &Type{kind: TY_INT, name: "int8", size: 1}
+	ty_int16   = &Type{kind: TY_INT, name: "int16", size: 2}
+	ty_int32   = &Type{kind: TY_INT, name: "int32", size: 4}
+	ty_int64   = &Type{kind: TY_INT, name: "int64", size: 8}
+	ty_int     = &Type{kind: TY_INT, name: "int", size: 8}
+	ty_uint8   = &Type{kind: TY_INT, name: "uint8", size: 1, unsigned: true}
+	ty_uint16  = &Type{kind: TY_INT, name: "uint16", size: 2, unsigned: true}
+	ty_uint32  = &Type{kind: TY_INT, name: "uint32", size: 4, unsigned: true}
+	ty_uint64  = &Type{kind: TY_INT, name: "uint64", size: 8, unsigned: true}
+	ty_uint    = &Type{kind: TY_INT, name: "uint", size: 8, unsigned: true}
+	ty_uintptr = &Type{kind: TY_INT, name: "uintptr", size: 8, unsigned: true}
+
+	ty_bool = &Type{kind: TY_BOOL, name: "bool", size: 1}
+
+	ty_untyped_int  = &Type{kind: TY_UNTYPED_INT, name: "untyped int", size: 8}
+	ty_untyped_bool = &Type{kind: TY_UNTYPED_BOOL, name: "untyped bool", size: 8}
+	ty_untyped_nil  = &Type{kind: TY_NIL, name: "untyped nil", size: 8}
+
+	// インターフェースの値のデータのワード 具象型のポインタの値か、具象型の値をコピーしたヒープ領域を指す
+	ty_data = &Type{kind: TY_PTR, name: "unsafe.Pointer", size: 8, base: ty_uint8}
+)
+
+// 事前宣言された型 byteとruneはそれぞれuint8とint32の別名
+var predeclared_types = map[string]*Type{
+	"int8":    ty_int8,
+	"int16":   ty_int16,
+	"int32":   ty_int32,
+	"int64":   ty_int64,
+	"int":     ty_int,
+	"uint8":   ty_uint8,
+	"uint16":  ty_uint16,
+	"uint32":  ty_uint32,
+	"uint64":  ty_uint64,
+	"uint":    ty_uint,
+	"uintptr": ty_uintptr,
+	"bool":    ty_bool,
+	"byte":    ty_uint8,
+	"rune":    ty_int32,
+}
+
+func pointer_to(base *Type) *Type {
+	return &Type{kind: TY_PTR, name: "*" + base.name, size: 8, base: base}
+}
+
+// スライスの値は先頭の要素へのポインタ、長さ、容量の3ワードからなる
+func slice_of(base *Type) *Type {
+	return &Type{kind: TY_SLICE, name: "[]" + base.name, size: 24, base: base}
+}
+
+// フィールドを並べた構造体型
+// フィールドは型の大きさに揃えた位置に置き、構造体の大きさはワードの倍数に切り上げる
+func struct_type(fields []*Field) *Type {
+	name := "struct {"
+	offset := 0
+	for i, f := range fields {
+		offset = align_to(offset, align_of(f.ty))
+		f.offset = offset
+		offset += f.ty.size
+		if i > 0 {
+			name += ";"
+		}
+		if f.embedded {
+			name += " " + f.ty.name
+		} else {
+			name += " " + f.name + " " + f.ty.name
+		}
+	}
+	if len(fields) > 0 {
+		name += " "
+	}
+	return &Type{kind: TY_STRUCT, name: name + "}", size: align_to(offset, 8), fields: fields}
+}
+
+// 型tyの値を置くアドレスの境界 構造体と複数ワードの値はワードの境界に置く
+func align_of(ty *Type) int {
+	if ty.kind == TY_STRUCT || ty.size > 8 {
+		return 8
+	}
+	return ty.size
+}
+
+// メソッドを並べたインターフェース型
+// インターフェースの値はメソッドの表へのポインタとデータのワードの2ワードからなる
+// メソッドは名前順に並べ、その順にメソッドの表に具象型のメソッドを呼び出す関数のアドレスを並べる
+func interface_type(methods []*Field) *Type {
+	sort.Slice(methods, func(i, j int) bool { return methods[i].name < methods[j].name })
+	name := "interface {"
+	for i, m := range methods {
+		m.offset = i * 8
+		if i > 0 {
+			name += ";"
+		}
+		name += " " + m.name + strings.TrimPrefix(m.ty.name, "func")
+	}
+	if len(methods) > 0 {
+		name += " "
+	}
+	return &Type{kind: TY_INTERFACE, name: name + "}", size: 16, fields: methods}
+}
+
+// 型宣言で定義した型tyの定義をunderの基底型にする
+// 定義した型は基底型と同じ種類と大きさの値を持ち、名前とメソッドだけが異なる
+// 定義を解析する前から型名として参照されていることがあるので、同じ領域に書き込む
+func define(ty *Type, under *Type) {
+	name, methods := ty.name, ty.methods
+	*ty = *underlying(under)
+	ty.name, ty.defined, ty.underlying, ty.methods = name, true, underlying(under), methods
+}
+
+// 型tyの基底型 定義した型でなければ型そのもの
+func underlying(ty *Type) *Type {
+	if ty.defined {
+		return ty.underlying
+	}
+	return ty
+}
+
+// 名前のある型かどうか 事前宣言された型と型宣言で定義した型には名前がある
+func is_named(ty *Type) bool {
+	return ty.defined || ty.kind == TY_INT || ty.kind == TY_BOOL
+}
+
+// 型tyの値が占める8バイト単位のワード数
+func words(ty *Type) int {
+	return align_to(ty.size, 8) / 8
+}
+
+// 型tyの値のうちヒープを指すポインタを入れるワードの位置
+// スライスは先頭のワードが配列へのポインタで、長さと容量は整数
+func ptrwords(ty *Type) []int {
+	switch ty.kind {
+	case TY_PTR, TY_SLICE, TY_FUNC:
+		return []int{0} // 関数の値はクロージャへのポインタ
+	case TY_INTERFACE:
+		return []int{1} // メソッドの表は静的な領域にある
+	case TY_STRUCT:
+		ptrs := []int{}
+		for _, f := range ty.fields {
+			for _, i := range ptrwords(f.ty) {
+				ptrs = append(ptrs, f.offset/8+i)
+			}
+		}
+		return ptrs
+	}
+	return nil
+}
+
+// 型のリストの値が占めるワード数の合計
+func total_words(types []*Type) int {
+	n := 0
+	for _, ty := range types {
+		n += words(ty)
+	}
+	return n
+}
+
+// 型のリストを"(int, *int)"の形式の文字列にする
+func type_list_name(types []*Type) string {
+	name := "("
+	for i, ty := range types {
+		if i > 0 {
+			name += ", "
+		}
+		name += ty.name
+	}
+	return name + ")"
+}
+
+// variadicなら最後の仮引数はスライス型の可変長引数になる
+func func_type(params []*Type, results []*Type, variadic bool) *Type {
+	name := "func" + type_list_name(params)
+	if variadic {
+		// 最後の仮引数の型は"[]int"ではなく"...int"と表す
+		last := params[len(params)-1]
+		name = strings.TrimSuffix(name, last.name+")") + "..." + last.base.name + ")"
+	}
+	switch len(results) {
+	case 0:
+	case 1:
+		name += " " + results[0].name
+	default:
+		name += " " + type_list_name(results)
+	}
+	return &Type{kind: TY_FUNC, name: name, size: 8, params: params, results: results, variadic: variadic}
+}
+
+// 関数の型fnの仮引数の後ろにCの可変長引数を加えた型
+// Cの可変長引数には1ワードの値を任意の個数だけ渡せる
+func c_variadic_type(fn *Type) *Type {
+	ty := *fn
+	prefix := "func" + type_list_name(fn.params)
+	ty.name = strings.TrimSuffix(prefix, ")") + ", ...)" + strings.TrimPrefix(fn.name, prefix)
+	ty.cvariadic = true
+	return &ty
+}
+
+// 関数呼び出しの型 結果が一つならその型、それ以外は結果の組
+func result_type(fn *Type) *Type {
+	if len(fn.results) == 1 {
+		return fn.results[0]
+	}
+	return &Type{kind: TY_TUPLE, name: type_list_name(fn.results), results: fn.results}
+}
+
+func is_integer(ty *Type) bool {
+	return ty.kind == TY_INT || ty.kind == TY_UNTYPED_INT
+}
+
+func is_bool(ty *Type) bool {
+	return ty.kind == TY_BOOL || ty.kind == TY_UNTYPED_BOOL
+}
+
+// 型tyの値をnilと比較できるかどうか
+func is_nillable(ty *Type) bool {
+	return ty.kind == TY_PTR || ty.kind == TY_SLICE || ty.kind == TY_FUNC || ty.kind == TY_INTERFACE
+}
+
+func is_unsigned(ty *Type) bool {
+	return ty.kind == TY_INT && ty.unsigned
+}
+
+// 2つの型が同一かどうか
+// 定義した型は他のどの型とも異なる
+func identical(t1 *Type, t2 *Type) bool {
+	if t1.defined || t2.defined {
+		return t1 == t2
+	}
+	if t1.kind == TY_PTR && t2.kind == TY_PTR || t1.kind == TY_SLICE && t2.kind == TY_SLICE {
+		return identical(t1.base, t2.base)
+	}
+	if t1.kind == TY_FUNC && t2.kind == TY_FUNC {
+		// 仮引数と結果の型が順に同一で、可変長かどうかも同じ関数型は同一
+		return t1.variadic == t2.variadic && identical_list(t1.params, t2.params) && identical_list(t1.results, t2.results)
+	}
+	if t1.kind == TY_STRUCT && t2.kind == TY_STRUCT || t1.kind == TY_INTERFACE && t2.kind == TY_INTERFACE {
+		// フィールドやメソッドの名前と型が順に同じなら同一
+		if len(t1.fields) != len(t2.fields) {
+			return false
+		}
+		for i, f := range t1.fields {
+			g := t2.fields[i]
+			if f.name != g.name || f.embedded != g.embedded || !identical(f.ty, g.ty) {
+				return false
+			}
+		}
+		return true
+	}
+	return t1 == t2
+}
+
+// 2つの型のリストが同じ長さで、要素の型が順に同一かどうか
+func identical_list(l1 []*Type, l2 []*Type) bool {
+	if len(l1) != len(l2) {
+		return false
+	}
+	for i := range l1 {
+		if !identical(l1[i], l2[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// 型tyの変数に型fromの値を代入できるかどうか
+func assignable(from *Type, ty *Type) bool {
+	if from.kind == TY_UNTYPED_INT {
+		return ty.kind == TY_INT // 型なし定数は整数型に暗黙に変換される
+	}
+	if from.kind == TY_UNTYPED_BOOL {
+		return ty.kind == TY_BOOL // 比較の結果や型なしの真理値定数はbool型に暗黙に変換される
+	}
+	if from.kind == TY_NIL {
+		return is_nillable(ty) // nilはポインタ、スライス、関数、インターフェースの型のゼロ値になる
+	}
+	if identical(from, ty) {
+		return true
+	}
+	if ty.kind == TY_INTERFACE && from.kind != TY_INTERFACE {
+		return implements(from, ty) // インターフェースを実装する型の値はインターフェースの値に変換される
+	}
+	// 基底型が同一なら、少なくとも一方が名前のない型であれば代入できる
+	return (!is_named(from) || !is_named(ty)) && identical(underlying(from), underlying(ty))
+}
+
+// 型なし定数を既定の型に変換する
+func default_type(ty *Type) *Type {
+	switch ty.kind {
+	case TY_UNTYPED_INT:
+		return ty_int
+	case TY_UNTYPED_BOOL:
+		return ty_bool
+	}
+	return ty
+}
+
+// 二項演算の両辺の型を一致させ、演算の型を返す
+// 片方が型なし定数なら、もう片方の型に変換する
+func (p *Parser) binary_type(node *Node) *Type {
+	lhs, rhs := node.lhs.ty, node.rhs.ty
+	switch {
+	case lhs.kind == TY_NIL && rhs.kind != TY_NIL:
+		lhs = rhs
+		p.check_const(node.lhs, rhs) // nilは比較する相手の型のゼロ値になる
+	case rhs.kind == TY_NIL && lhs.kind != TY_NIL:
+		rhs = lhs
+		p.check_const(node.rhs, lhs)
+	case lhs.kind == TY_UNTYPED_INT && rhs.kind == TY_INT:
+		lhs = rhs
+		p.check_const(node.lhs, rhs)
+	case rhs.kind == TY_UNTYPED_INT && lhs.kind == TY_INT:
+		rhs = lhs
+		p.check_const(node.rhs, lhs)
+	case lhs.kind == TY_UNTYPED_BOOL && rhs.kind == TY_BOOL:
+		lhs = rhs
+	case rhs.kind == TY_UNTYPED_BOOL && lhs.kind == TY_BOOL:
+		rhs = lhs
+	}
+	if !identical(lhs, rhs) {
+		error_tok(p.code, node.token, "型が一致しません(%s と %s)", node.lhs.ty.name, node.rhs.ty.name)
+	}
+	return lhs
+}
+
+// 比較の両辺の型を一致させ、その型を返す
+// 定数でない型なしの値どうしの比較では、両辺を既定の型の値として計算する
+func (p *Parser) compare_type(node *Node) *Type {
+	ty := p.binary_type(node)
+	if ty.kind == TY_UNTYPED_INT && (node.lhs.kind != ND_NUM || node.rhs.kind != ND_NUM) {
+		p.check_const(node.lhs, default_type(ty))
+		p.check_const(node.rhs, default_type(ty))
+		return default_type(ty)
+	}
+	return ty
+}
+
+// 式のノードに型を付け、型の誤りを検査する
+func (p *Parser) add_type(node *Node) {
+	if node == nil || node.ty != nil {
+		return
+	}
+
+	p.add_type(node.lhs)
+	p.add_type(node.rhs)
+	for _, n := range node.args {
+		p.add_type(n)
+	}
+	values := append([]*Node{node.lhs, node.rhs}, node.args...)
+	if node.kind == ND_FUNCCALL && len(node.args) == 1 {
+		values = nil // f(g())のように複数の値を返す関数呼び出しを唯一の引数にできる
+	}
+	for _, n := range values {
+		if n != nil {
+			p.check_value(n)
+		}
+	}
+
+	switch node.kind {
+	case ND_ADD, ND_SUB, ND_MUL, ND_DIV, ND_MOD, ND_AND, ND_OR, ND_XOR, ND_ANDNOT:
+		node.ty = p.binary_type(node)
+		if !is_integer(node.ty) {
+			error_tok(p.code, node.token, "%s型には演算子%sを使用できません", node.ty.name, node.token.val)
+		}
+		if (node.kind == ND_DIV || node.kind == ND_MOD) && node.rhs.kind == ND_NUM && node.rhs.num.Sign() == 0 {
+			error_tok(p.code, node.token, "ゼロで除算しています") // 左辺が定数でなくても定数の0では割れない
+		}
+	case ND_SHL, ND_SHR:
+		if !is_integer(node.lhs.ty) || !is_integer(node.rhs.ty) {
+			error_tok(p.code, node.token, "シフト演算の被演算子は整数でなければなりません")
+		}
+		if node.rhs.kind == ND_NUM && node.rhs.num.Sign() < 0 {
+			error_tok(p.code, node.rhs.token, "シフト回数%sが負です", node.rhs.num)
+		}
+		if node.rhs.kind != ND_NUM {
+			p.check_const(node.rhs, ty_uint) // 回数が型なしの式ならuintとして計算する
+		}
+		// シフト演算の型は左辺の型
+		// 定数でない回数でシフトする型なし定数は、使われる文脈の型がcheck_constで決まる
+		node.ty = node.lhs.ty
+	case ND_EQ, ND_NE:
+		nilcmp := node.lhs.ty.kind == TY_NIL || node.rhs.ty.kind == TY_NIL // スライスと関数もnilとは比較できる
+		switch p.compare_type(node).kind {
+		case TY_NIL:
+			error_tok(p.code, node.token, "nil同士は比較できません")
+		case TY_SLICE:
+			if !nilcmp {
+				error_tok(p.code, node.token, "スライスはnilとしか比較できません")
+			}
+		case TY_FUNC:
+			if !nilcmp {
+				error_tok(p.code, node.token, "関数はnilとしか比較できません")
+			}
+		case TY_INTERFACE:
+			if !nilcmp {
+				error_tok(p.code, node.token, "インターフェースはnilとしか比較できません")
+			}
+		case TY_STRUCT:
+			error_tok(p.code, node.token, "構造体の比較には対応していません")
+		}
+		node.ty = ty_untyped_bool
+	case ND_LT, ND_LE:
+		if !is_integer(p.compare_type(node)) {
+			error_tok(p.code, node.token, "%s型は大小比較できません", node.lhs.ty.name)
+		}
+		node.ty = ty_untyped_bool
+	case ND_LOGAND, ND_LOGOR:
+		node.ty = p.binary_type(node)
+		if !is_bool(node.ty) {
+			error_tok(p.code, node.token, "%s型には演算子%sを使用できません", node.ty.name, node.token.val)
+		}
+	case ND_BITNOT:
+		if !is_integer(node.lhs.ty) {
+			error_tok(p.code, node.token, "%s型には演算子^を使用できません", node.lhs.ty.name)
+		}
+		node.ty = node.lhs.ty
+	case ND_ADDR:
+		if !addressable(node.lhs) {
+			error_tok(p.code, node.token, "アドレスが取得できません")
+		}
+		node.ty = pointer_to(node.lhs.ty)
+	case ND_DEREF:
+		if node.lhs.ty.kind != TY_PTR {
+			error_tok(p.code, node.token, "ポインタ型ではない値を参照しています")
+		}
+		node.ty = node.lhs.ty.base
+	case ND_INDEX:
+		if node.lhs.ty.kind != TY_SLICE {
+			error_tok(p.code, node.token, "%s型の値はインデックスで参照できません", node.lhs.ty.name)
+		}
+		if !is_integer(node.rhs.ty) {
+			error_tok(p.code, node.rhs.token, "インデックスが整数ではありません")
+		}
+		if node.rhs.kind == ND_NUM && node.rhs.num.Sign() < 0 {
+			error_tok(p.code, node.rhs.token, "インデックス%sが負です", node.rhs.num)
+		}
+		p.check_const(node.rhs, ty_int)
+		node.ty = node.lhs.ty.base
+	case ND_LEN, ND_CAP:
+		if node.lhs.ty.kind != TY_SLICE {
+			error_tok(p.code, node.lhs.token, "%s型の値は%sの引数にできません", node.lhs.ty.name, node.val)
+		}
+		node.ty = ty_int
+	case ND_PRINT:
+		for _, arg := range node.args {
+			if !is_integer(arg.ty) && !is_bool(arg.ty) && !is_nillable(arg.ty) || arg.ty.kind == TY_INTERFACE {
+				error_tok(p.code, arg.token, "%s型の値は%sの引数にできません", arg.ty.name, node.val)
+			}
+			p.check_const(arg, default_type(arg.ty)) // 型なし定数は既定の型の値として出力する
+		}
+		node.ty = result_type(func_type(nil, nil, false))
+	case ND_VAR:
+		node.ty = node.variable.ty
+	case ND_NUM:
+		node.ty = ty_untyped_int
+	}
+	p.fold(node) // 定数式はコード生成の前に計算しておく
+}
+
+// 式が単一の値を持つことを検査する
+func (p *Parser) check_value(node *Node) {
+	if node.ty.kind != TY_TUPLE {
+		return
+	}
+	if len(node.ty.results) == 0 {
+		error_tok(p.code, node.token, "%s()は値を返しません", node.val)
+	}
+	error_tok(p.code, node.token, "%s()は複数の値を返します", node.val)
+}
+
+// if文とfor文の条件式が真理値であることを検査する
+func (p *Parser) check_cond(node *Node) {
+	p.check_value(node)
+	if !is_bool(node.ty) {
+		error_tok(p.code, node.token, "%s型の値は条件式に使えません", node.ty.name)
+	}
+}
+
+// 式のリストが表す値の型のリスト
+// 複数の値を返す関数呼び出しはその結果の型を並べる
+// 関数呼び出しだけからなるリストのほかに、メソッドの呼び出しのレシーバと引数の関数呼び出しのリストがある
+func value_types(exprs []*Node) []*Type {
+	types := []*Type{}
+	for _, expr := range exprs {
+		if expr.ty.kind == TY_TUPLE {
+			types = append(types, expr.ty.results...)
+		} else {
+			types = append(types, expr.ty)
+		}
+	}
+	return types
+}
+
+// 複数の値を返す関数呼び出しの型fromの結果を型tyの値として使えることを検査する
+// 結果はそのまま代入や引数に使うので、インターフェースの値に変換しなければならない値は使えない
+func (p *Parser) check_tuple_value(token *Token, from *Type, ty *Type) {
+	if ty.kind == TY_INTERFACE && !identical(from, ty) {
+		error_tok(p.code, token, "複数の値を返す関数呼び出しの%s型の結果を%s型の値に変換できません", from.name, ty.name)
+	}
+}
+
+// 式nodeの値がアドレスを取得できる場所にあるかどうか
+// 構造体のフィールドは構造体がアドレスを取得できる場所にあるか、ポインタを通じて選んだときに取得できる
+func addressable(node *Node) bool {
+	switch node.kind {
+	case ND_VAR, ND_DEREF, ND_INDEX:
+		return true
+	case ND_MEMBER:
+		return node.lhs.ty.kind == TY_PTR || addressable(node.lhs)
+	}
+	return false
+}
+
+// アドレスを取得する式nodeが変数そのものかそのフィールドなら、その変数のアドレスが取られたことを記録する
+func mark_addressed(node *Node) {
+	for node.kind == ND_MEMBER && node.lhs.ty.kind != TY_PTR {
+		node = node.lhs
+	}
+	if node.kind == ND_VAR {
+		node.variable.addressed = true
+	}
+}
+
+// 代入文の型を検査する
+func (p *Parser) check_assign(node *Node) {
+	if node.kind == ND_OPASSIGN_STMT {
+		// 複合代入は左辺と右辺の二項演算の結果を代入する
+		rhs := &Node{kind: node.op, token: node.token, lhs: node.lhs, rhs: node.rhs}
+		p.add_type(rhs)
+		p.check_assign_value(node.token, node.lhs, rhs.ty)
+		return
+	}
+	types := value_types(node.rhslist)
+	if len(types) != len(node.lhslist) {
+		error_tok(p.code, node.token, "代入の個数が一致しません(%d個の変数に%d個の値)", len(node.lhslist), len(types))
+	}
+	for i, lhs := range node.lhslist {
+		if lhs.ty == nil {
+			// 代入文の左辺のブランク識別子は右辺の値の型の変数になる
+			lhs.ty = default_type(types[i])
+			lhs.variable.ty = lhs.ty
+		}
+		p.check_assign_value(node.token, lhs, types[i])
+		if len(node.rhslist) == len(types) {
+			p.check_const(node.rhslist[i], lhs.ty)
+		} else {
+			p.check_tuple_value(node.rhslist[0].token, types[i], lhs.ty)
+		}
+	}
+}
+
+// 左辺lhsに型tyの値を代入できることを検査する
+func (p *Parser) check_assign_value(token *Token, lhs *Node, ty *Type) {
+	if !addressable(lhs) {
+		error_tok(p.code, lhs.token, "代入できません")
+	}
+	if lhs.ty.kind == TY_NIL {
+		error_tok(p.code, token, "型が決まらないnilは代入に使えません") // x := nil
+	}
+	if !assignable(ty, lhs.ty) {
+		error_tok(p.code, token, "%s型の値を%s型の変数に代入できません", ty.name, lhs.ty.name)
+	}
+}